@@ -57,3 +57,31 @@ func Benchmark_HTMLCSSModulesParser_ParseTo(b *testing.B) {
 		buf.Reset()
 	}
 }
+
+func Benchmark_CompiledHTML_Render(b *testing.B) {
+	// Example with go templates
+	s := `{{ template "test-template" .variable }}
+<nav>
+	<ul>
+		<li>
+			<a href="/home" css-module="class-1"><img src="/logo.png">Test Logo</a>
+		</li>
+		<li>
+			<a href="/test" css-module="class-2">Link to Test</a>
+		</li>
+	</ul>
+</nav>`
+	classes := cssmodules.ClassMap{"class-1": "RAN_1", "class-2": "RAN_2"}
+	compiled, err := cssmodules.CompileHTML(bytes.NewBufferString(s))
+	if err != nil {
+		b.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := compiled.Render(buf, classes); err != nil {
+			b.Error(err)
+		}
+		buf.Reset()
+	}
+}
@@ -2,6 +2,8 @@ package benchmarks
 
 import (
 	"bytes"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/otaxhu/go-cssmodules"
@@ -29,6 +31,7 @@ func Benchmark_ProcessCSSModules(b *testing.B) {
 
 }`
 	buffer := &bytes.Buffer{}
+	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
 		buffer.WriteString(s)
 		if _, _, err := cssmodules.ProcessCSSModules(buffer); err != nil {
@@ -61,6 +64,7 @@ func Benchmark_CSSModulesParser_ParseTo(b *testing.B) {
 }`
 	payloadBuf := &bytes.Buffer{}
 	buf := &bytes.Buffer{}
+	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
 		payloadBuf.WriteString(s)
 		if _, err := cssmodules.NewCSSModulesParser(payloadBuf).ParseTo(buf); err != nil {
@@ -70,3 +74,44 @@ func Benchmark_CSSModulesParser_ParseTo(b *testing.B) {
 		buf.Reset()
 	}
 }
+
+// designSystemCSS builds a big stylesheet where the same classes are used many
+// times, like the ones found in design systems
+func designSystemCSS() string {
+	sb := &strings.Builder{}
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(sb, `.btn-%d, .btn-%d:hover, .card .btn-%d {
+	color: red;
+	padding: 4px 8px;
+}
+@media screen and (min-width: 768px) {
+	.btn-%d > .icon { margin: 0 }
+}
+`, i%40, i%40, i%40, i%40)
+	}
+	return sb.String()
+}
+
+func Benchmark_ProcessCSSModules_DesignSystem(b *testing.B) {
+	s := designSystemCSS()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(s)))
+	for i := 0; i < b.N; i++ {
+		if _, _, err := cssmodules.ProcessCSSModules(strings.NewReader(s)); err != nil {
+			b.Error(err)
+		}
+	}
+}
+
+func Benchmark_CSSModulesParser_ParseTo_DesignSystem(b *testing.B) {
+	s := designSystemCSS()
+	buf := &bytes.Buffer{}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(s)))
+	for i := 0; i < b.N; i++ {
+		if _, err := cssmodules.NewCSSModulesParser(strings.NewReader(s)).ParseTo(buf); err != nil {
+			b.Error(err)
+		}
+		buf.Reset()
+	}
+}
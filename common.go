@@ -1,9 +1,11 @@
 package cssmodules
 
 import (
+	"bufio"
 	"bytes"
-	"hash/adler32"
+	"context"
 	"io"
+	"strconv"
 	"sync"
 )
 
@@ -13,7 +15,39 @@ type writer interface {
 	io.StringWriter
 }
 
-var adlerHashFunction = adler32.New()
+// errWriter is a writer keeping the first error returned by w, the writes after
+// it are discarded. The processors stop once err is set, instead of processing
+// the rest of the input for a writer that can't be written anymore.
+type errWriter struct {
+	w   writer
+	err error
+}
+
+func (ew *errWriter) Write(b []byte) (int, error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+	n, err := ew.w.Write(b)
+	ew.err = err
+	return n, err
+}
+
+func (ew *errWriter) WriteByte(c byte) error {
+	if ew.err != nil {
+		return ew.err
+	}
+	ew.err = ew.w.WriteByte(c)
+	return ew.err
+}
+
+func (ew *errWriter) WriteString(s string) (int, error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+	n, err := ew.w.WriteString(s)
+	ew.err = err
+	return n, err
+}
 
 // Buffer pool
 var bp = sync.Pool{
@@ -34,3 +68,69 @@ func releaseBuffer(b *bytes.Buffer) {
 		bp.Put(b)
 	}
 }
+
+// Size of the buffer used for streaming the output to writers that don't
+// implement writer
+const streamBufferSize = 4096
+
+// bufio.Writer pool
+var bwp = sync.Pool{
+	New: func() any {
+		return bufio.NewWriterSize(nil, streamBufferSize)
+	},
+}
+
+func getBufioWriter(w io.Writer) *bufio.Writer {
+	bw := bwp.Get().(*bufio.Writer)
+	bw.Reset(w)
+	return bw
+}
+
+func releaseBufioWriter(bw *bufio.Writer) {
+	if bw != nil {
+		bw.Reset(nil)
+		bwp.Put(bw)
+	}
+}
+
+// Position is a position in the input being processed.
+type Position struct {
+	// Offset is the byte offset, starting at 0
+	Offset int
+	// Line is the line number, starting at 1
+	Line int
+	// Column is the byte offset in the line, starting at 1
+	Column int
+}
+
+func (p Position) String() string {
+	return strconv.Itoa(p.Line) + ":" + strconv.Itoa(p.Column)
+}
+
+// advance moves the position past b.
+func (p *Position) advance(b []byte) {
+	p.Offset += len(b)
+	if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+		p.Line += bytes.Count(b, []byte{'\n'})
+		p.Column = len(b) - i
+	} else {
+		p.Column += len(b)
+	}
+}
+
+// positionOf returns the position of the offset in b.
+func positionOf(b []byte, offset int) Position {
+	pos := Position{Line: 1, Column: 1}
+	pos.advance(b[:offset])
+	return pos
+}
+
+// contextErr returns the error of ctx if it's done, without blocking.
+func contextErr(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return nil
+	}
+}
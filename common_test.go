@@ -0,0 +1,34 @@
+package cssmodules
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// cancelAfterContext is a context that is done after its Done method is called
+// n times, used for cancelling the processing at a known token.
+type cancelAfterContext struct {
+	context.Context
+	n    int32
+	done chan struct{}
+}
+
+func newCancelAfterContext(n int32) *cancelAfterContext {
+	done := make(chan struct{})
+	close(done)
+	return &cancelAfterContext{Context: context.Background(), n: n, done: done}
+}
+
+func (c *cancelAfterContext) Done() <-chan struct{} {
+	if atomic.AddInt32(&c.n, -1) < 0 {
+		return c.done
+	}
+	return nil
+}
+
+func (c *cancelAfterContext) Err() error {
+	if atomic.LoadInt32(&c.n) < 0 {
+		return context.Canceled
+	}
+	return nil
+}
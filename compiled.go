@@ -0,0 +1,162 @@
+package cssmodules
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	html_parser "golang.org/x/net/html"
+)
+
+// CompiledHTML is an HTML document tokenized once by CompileHTML, so it can be
+// rendered with different classes without processing it again. It's safe for
+// concurrent use.
+type CompiledHTML struct {
+	cfg *config
+
+	// html is the document without the classes of the `css-module` attributes,
+	// slots are the places of the classes in it and refs the classes of every
+	// slot
+	html  []byte
+	slots []classSlot
+	refs  []classRef
+}
+
+// classSlot is the place of the classes of a tag in a compiled document.
+type classSlot struct {
+	offset int
+	// space is set if the classes follow the classes of the target attribute
+	// written in the document, target are the classes of the target attribute
+	// merged with them otherwise
+	space  bool
+	target []string
+	// module is the module of the `css-module-scope` of the tag, nil for the
+	// resolver passed to Render
+	module ClassResolver
+	// refs are the classes of the slot in the refs of the document
+	refs [2]int
+}
+
+// classRef is a class of a `css-module` attribute and its position.
+type classRef struct {
+	name []byte
+	pos  Position
+}
+
+// CompileHTML tokenizes the HTML read from r once, leaving a slot for the
+// classes of every tag with a `css-module` attribute, so rendering it doesn't
+// parse it again. The `css-module-scope` attributes are resolved with the
+// modules of the options when compiling.
+//
+// The options rewriting the document with the scoped names, like
+// WithDynamicClasses, WithIDScoping, WithStyleRewriting, WithStyleModules and
+// WithLinkModules, are ignored.
+func CompileHTML(r io.Reader, opts ...Option) (*CompiledHTML, error) {
+	cfg := newConfig(opts)
+	cfg.dynamicClasses, cfg.idScoping, cfg.styleRewriting, cfg.styleModules = false, false, false, false
+	cfg.linkFS = nil
+
+	t := &CompiledHTML{cfg: cfg}
+	p := newHTMLProcessor(cfg)
+	p.compiled, p.out = t, &bytes.Buffer{}
+	if err := p.process(context.Background(), r, p.out, nil); err != nil {
+		return nil, err
+	}
+	t.html = p.out.Bytes()
+	return t, nil
+}
+
+func (t *CompiledHTML) addSlot(offset int, space bool, target []string, alias string, module ClassResolver) {
+	start := 0
+	if len(t.slots) != 0 {
+		start = t.slots[len(t.slots)-1].refs[1]
+	}
+	if alias == "" {
+		module = nil
+	}
+	t.slots = append(t.slots, classSlot{offset: offset, space: space, target: target, module: module, refs: [2]int{start, len(t.refs)}})
+}
+
+// Render writes the document with the classes resolved with r, or with the
+// resolver of the options if it's nil. The errors are the same as the ones of
+// ProcessHTMLWithCSSModules, but the document may be partially written when
+// an error other than a *MissingClassesError is returned. The lookups in a
+// ClassMap don't allocate.
+func (t *CompiledHTML) Render(w io.Writer, r ClassResolver) error {
+	if r == nil {
+		r = t.cfg.resolverFor(nil)
+	}
+	if x, ok := w.(writer); ok {
+		return t.render(x, r)
+	}
+	// The output is streamed through a fixed size buffer
+	bw := getBufioWriter(w)
+	defer releaseBufioWriter(bw)
+	err := t.render(bw, r)
+	var missingErr *MissingClassesError
+	if err != nil && !errors.As(err, &missingErr) {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return err
+}
+
+func (t *CompiledHTML) render(w writer, r ClassResolver) error {
+	var missing []MissingClass
+	// seen is only used by the Dedupe merge policy
+	var seen []string
+	written := 0
+	for i := range t.slots {
+		s := &t.slots[i]
+		w.Write(t.html[written:s.offset])
+		written = s.offset
+
+		resolver := r
+		if s.module != nil {
+			resolver = s.module
+		}
+		cw := classWriter{w: w, space: s.space, dedupe: t.cfg.merge.Dedupe, seen: seen[:0]}
+		if t.cfg.merge.Order != ModuleClassesFirst {
+			for _, class := range s.target {
+				cw.write(class)
+			}
+		}
+		placeholder := false
+		for _, ref := range t.refs[s.refs[0]:s.refs[1]] {
+			// The scratch space doesn't escape, so resolving the classes
+			// doesn't allocate
+			var scratch [4]string
+			classes, err := t.cfg.appendClasses(scratch[:0], resolver, ref.name)
+			if err != nil {
+				class, policyErr := t.cfg.missingClass(ref.name, err)
+				if policyErr != nil {
+					return policyErr
+				}
+				missing = append(missing, MissingClass{Pos: ref.pos, Name: string(ref.name), Err: err})
+				// The placeholder class is written once per tag
+				if class == "" || (t.cfg.missingPolicy == MissingClassPlaceholder && placeholder) {
+					continue
+				}
+				placeholder = true
+				classes = append(classes[:0], class)
+			}
+			for _, class := range classes {
+				cw.write(html_parser.EscapeString(class))
+			}
+		}
+		if t.cfg.merge.Order == ModuleClassesFirst {
+			for _, class := range s.target {
+				cw.write(class)
+			}
+		}
+		seen = cw.seen
+	}
+	w.Write(t.html[written:])
+	if len(missing) != 0 {
+		return &MissingClassesError{Missing: missing}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package cssmodules
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompileHTML(t *testing.T) {
+	classes := map[string]string{"card": "RAN_1", "title": "RAN_2", "a&b": "RAN_<3>"}
+	testCases := []struct {
+		name    string
+		opts    []Option
+		payload string
+	}{
+		{
+			name:    "Merge",
+			payload: `<div class=" box " css-module="card"><h1 css-module="title" id="t">Hi</h1><p class="x">{{.}}</p></div>`,
+		},
+		{
+			name:    "EmptyTarget",
+			payload: `<div class="" css-module="card title"></div><img css-module="title">`,
+		},
+		{
+			name:    "Escaped",
+			payload: `<a css-module="a&amp;b card">x</a>`,
+		},
+		{
+			name:    "Scope",
+			opts:    []Option{WithModule("button", map[string]string{"root": "BTN_1"})},
+			payload: `<nav css-module-scope="button"><a css-module="root"></a></nav><a css-module="button:root card"></a>`,
+		},
+		{
+			name:    "SeveralSources",
+			opts:    []Option{WithSourceAttributes("css-module", "data-css-module")},
+			payload: `<div data-css-module="title" css-module="card"></div>`,
+		},
+		{
+			name:    "WithoutClasses",
+			payload: `<!DOCTYPE html><p>Hi</p>`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expected, err := ProcessHTMLWithCSSModules(strings.NewReader(tc.payload), classes, tc.opts...)
+			if err != nil {
+				t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+			}
+			compiled, err := CompileHTML(strings.NewReader(tc.payload), tc.opts...)
+			if err != nil {
+				t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+			}
+			// The document is rendered twice with the same result
+			for i := 0; i < 2; i++ {
+				sb := strings.Builder{}
+				if err := compiled.Render(&sb, ClassMap(classes)); err != nil {
+					t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+				}
+				if sb.String() != string(expected) {
+					t.Errorf("unexpected html value: expected\n%s\ngot\n%s", expected, sb.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCompileHTML_Missing(t *testing.T) {
+	compiled, err := CompileHTML(strings.NewReader(`<p css-module="card unknown">x</p>`+"\n"+`<p css-module="other">y</p>`), WithMissingClassPolicy(MissingClassPlaceholder))
+	if err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	sb := strings.Builder{}
+	err = compiled.Render(&sb, ClassMap{"card": "RAN_1"})
+	var missingErr *MissingClassesError
+	if !errors.As(err, &missingErr) || missingErr.Error() != "css modules class not found: unknown (at 1:21), other (at 2:16)" {
+		t.Errorf("unexpected error value: expected *MissingClassesError got %v", err)
+	}
+	expected := `<p class="RAN_1 css-module-missing">x</p>` + "\n" + `<p class="css-module-missing">y</p>`
+	if sb.String() != expected {
+		t.Errorf("unexpected html value: expected\n%s\ngot\n%s", expected, sb.String())
+	}
+
+	// The default policy stops at the first missing class
+	compiled, _ = CompileHTML(strings.NewReader(`<p css-module="unknown">x</p>`))
+	if err := compiled.Render(&strings.Builder{}, ClassMap{}); !errors.Is(err, ErrClassNotFound) {
+		t.Errorf("unexpected error value: expected %v got %v", ErrClassNotFound, err)
+	}
+
+	if _, err := CompileHTML(strings.NewReader(`<p css-module-scope="unknown">x</p>`)); !errors.Is(err, ErrModuleNotFound) {
+		t.Errorf("unexpected error value: expected %v got %v", ErrModuleNotFound, err)
+	}
+}
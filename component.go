@@ -0,0 +1,173 @@
+package cssmodules
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	html_parser "golang.org/x/net/html"
+)
+
+// Component is a single-file component compiled by CompileComponent.
+type Component struct {
+	Name string
+
+	// CSS is the CSS processed of the `<style module>` sections followed by
+	// the CSS of the `<style>` sections as it is
+	CSS []byte
+
+	// Template is the `<template>` section processed with the classes of the
+	// component, wrapped in a definition named after the component
+	Template string
+
+	Classes map[string]string
+}
+
+var templateTag = []byte("template")
+
+// CompileComponent compiles a single-file component, an HTML file with a
+// `<template>` section and any number of top level `<style module>` and
+// `<style>` sections:
+//
+//	<style module>.card{padding:1rem}</style>
+//	<template><div css-module="card">{{.}}</div></template>
+//
+// The options are used for processing both the CSS and the HTML.
+func CompileComponent(name string, src []byte, opts ...Option) (*Component, error) {
+	cfg := newConfig(opts)
+	c := &Component{Name: name, Classes: map[string]string{}}
+	css, globalCSS := bytes.Buffer{}, bytes.Buffer{}
+
+	// The template section is found by the offsets of the tokens, depth counts
+	// the nested `<template>` elements
+	var body []byte
+	offset, start, depth := 0, -1, 0
+	zz := html_parser.NewTokenizer(bytes.NewReader(src))
+	next := func() (html_parser.TokenType, []byte) {
+		zt := zz.Next()
+		raw := zz.Raw()
+		offset += len(raw)
+		return zt, raw
+	}
+	for {
+		zt, raw := next()
+		if zt == html_parser.ErrorToken {
+			if err := zz.Err(); err != io.EOF {
+				return nil, err
+			}
+			break
+		}
+		switch zt {
+		case html_parser.StartTagToken:
+			nameEnd, attrs := scanTag(raw, nil, &cfg.delims)
+			if bytes.EqualFold(raw[1:nameEnd], templateTag) {
+				if depth == 0 {
+					start = offset
+				}
+				depth++
+				continue
+			}
+			if depth != 0 || !bytes.EqualFold(raw[1:nameEnd], styleTag) {
+				continue
+			}
+			module := styleModuleAttr(raw, nameEnd, attrs) != -1
+			zt, raw = next()
+			if zt != html_parser.TextToken {
+				continue
+			}
+			if !module {
+				globalCSS.Write(raw)
+				globalCSS.WriteByte('\n')
+				continue
+			}
+			processed, classes, err := ProcessCSSModules(bytes.NewReader(raw), opts...)
+			if err != nil {
+				return nil, fmt.Errorf("cssmodules: component %s: %w", name, err)
+			}
+			css.Write(processed)
+			css.WriteByte('\n')
+			for k, v := range classes {
+				c.Classes[k] = v
+			}
+		case html_parser.EndTagToken:
+			if depth == 0 || !bytes.EqualFold(endTagName(raw), templateTag) {
+				continue
+			}
+			depth--
+			if depth == 0 && body == nil {
+				body = src[start : offset-len(raw)]
+			}
+		}
+	}
+	if body == nil {
+		return nil, fmt.Errorf("cssmodules: component %s has no <template> section", name)
+	}
+
+	processed, err := ProcessHTMLWithCSSModules(bytes.NewReader(body), c.Classes, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("cssmodules: component %s: %w", name, err)
+	}
+	css.Write(globalCSS.Bytes())
+	c.CSS = css.Bytes()
+
+	sb := strings.Builder{}
+	sb.Write(cfg.delims.left)
+	sb.WriteString("define ")
+	sb.WriteString(strconv.Quote(name))
+	sb.Write(cfg.delims.right)
+	sb.Write(processed)
+	sb.Write(cfg.delims.left)
+	sb.WriteString("end")
+	sb.Write(cfg.delims.right)
+	c.Template = sb.String()
+	return c, nil
+}
+
+// ParseComponentsFS compiles the single-file components matching the patterns
+// with the options, see CompileComponent, and parses their definitions into t.
+// The components are named after their file without the extension, `card` for
+// `components/card.gohtml`. It returns the CSS of every component
+// concatenated.
+//
+// The functions of FuncMap are added to t like ParseFS does, the classes of
+// every component are registered under its name and its dynamic classes are
+// resolved with them. Other functions called by the components must be added
+// to t beforehand. If t is nil a new template is used.
+func ParseComponentsFS(t *template.Template, fsys fs.FS, patterns []string, opts ...Option) (*template.Template, []byte, error) {
+	files, err := globFS(fsys, patterns)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t == nil {
+		t = template.New("")
+	}
+	cfg := newConfig(opts)
+	css := bytes.Buffer{}
+	components := make([]*Component, len(files))
+	funcOpts := append([]Option(nil), opts...)
+	for i, file := range files {
+		src, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, nil, err
+		}
+		name := templateAlias(file)
+		c, err := CompileComponent(name, src, append(opts[:len(opts):len(opts)], withDocumentAlias(name))...)
+		if err != nil {
+			return nil, nil, err
+		}
+		css.Write(c.CSS)
+		components[i] = c
+		funcOpts = append(funcOpts, withModuleResolver(name, cfg.resolverFor(c.Classes)))
+	}
+	t.Funcs(FuncMap(nil, funcOpts...))
+	for _, c := range components {
+		if _, err := t.Parse(c.Template); err != nil {
+			return nil, nil, err
+		}
+	}
+	return t, css.Bytes(), nil
+}
@@ -0,0 +1,91 @@
+package cssmodules
+
+import (
+	"html/template"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCompileComponent(t *testing.T) {
+	src := `<style module>.card{padding:1rem}</style>
+<template>
+  <div css-module="card">{{template "title" .}}<template><p css-module="card"></p></template></div>
+</template>
+<style>body{margin:0}</style>
+<style module>.title{color:red}</style>`
+	c, err := CompileComponent("card", []byte(src))
+	if err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	expand := func(s string) string { return c.Classes[s] }
+
+	expectedCSS := os.Expand(".${card}{padding:1rem}\n.${title}{color:red}\nbody{margin:0}\n", expand)
+	if len(c.Classes) != 2 || string(c.CSS) != expectedCSS {
+		t.Errorf("unexpected css value: expected %q got %q with %q map", expectedCSS, c.CSS, c.Classes)
+	}
+	expectedTemplate := os.Expand(`{{define "card"}}
+  <div class="${card}">{{template "title" .}}<template><p class="${card}"></p></template></div>
+{{end}}`, expand)
+	if c.Template != expectedTemplate {
+		t.Errorf("unexpected template value: expected %s got %s", expectedTemplate, c.Template)
+	}
+
+	if _, err := CompileComponent("card", []byte(`<style module>.card{}</style>`)); err == nil {
+		t.Errorf("unexpected error value: expected missing template error got <nil>")
+	}
+}
+
+func TestParseComponentsFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"components/card.gohtml":   {Data: []byte(`<style module>.root{padding:1rem}</style><template><div css-module="root">{{template "button" .}}</div></template>`)},
+		"components/button.gohtml": {Data: []byte(`<style module>.root{color:red}</style><template><button css-module="root">{{.}}</button></template>`)},
+	}
+	tmpl, css, err := ParseComponentsFS(nil, fsys, []string{"components/*.gohtml"})
+	if err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	var sb strings.Builder
+	if err := tmpl.ExecuteTemplate(&sb, "card", "Ok"); err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+
+	// Each component has its own root class
+	button, card, _ := strings.Cut(string(css), "\n")
+	button = strings.TrimPrefix(strings.TrimSuffix(button, "{color:red}"), ".")
+	card = strings.TrimPrefix(strings.TrimSuffix(card, "{padding:1rem}\n"), ".")
+	expectedHTML := `<div class="` + card + `"><button class="` + button + `">Ok</button></div>`
+	if button == card || sb.String() != expectedHTML {
+		t.Errorf("unexpected html value: expected %s got %s", expectedHTML, sb.String())
+	}
+}
+
+func TestParseComponentsFS_Options(t *testing.T) {
+	fsys := fstest.MapFS{
+		"card.gohtml":   {Data: []byte(`<style module>.card-root{padding:1rem}.big{margin:0}</style><template><div css-module="card-root text {{.Variant}}"><p {{cssclass "text"}}></p>{{template "button" "big"}}</div></template>`)},
+		"button.gohtml": {Data: []byte(`<style module>.big{color:red}</style><template><button css-module="{{.}}"></button></template>`)},
+	}
+	// The dynamic classes are resolved with the module of their component, the
+	// shared classes with the resolver
+	shared := WithResolver(ClassMap{"text": "_text_abc"})
+	tmpl, css, err := ParseComponentsFS(template.New(""), fsys, []string{"*.gohtml"}, WithDynamicClasses(), WithLocalsConvention(LocalsCamelCaseOnly), shared)
+	if err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	var sb strings.Builder
+	if err := tmpl.ExecuteTemplate(&sb, "card", map[string]string{"Variant": "big"}); err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	// button.gohtml is compiled first
+	classes := regexp.MustCompile(`\._[\w-]+_[\w-]{6}`).FindAllString(string(css), -1)
+	if len(classes) != 3 {
+		t.Fatalf("unexpected css value: expected 3 classes got %q", css)
+	}
+	buttonBig, root, cardBig := classes[0][1:], classes[1][1:], classes[2][1:]
+	expectedHTML := `<div class="` + root + ` _text_abc ` + cardBig + `"><p class="_text_abc"></p><button class="` + buttonBig + `"></button></div>`
+	if buttonBig == cardBig || sb.String() != expectedHTML {
+		t.Errorf("unexpected html value: expected %s got %s", expectedHTML, sb.String())
+	}
+}
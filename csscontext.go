@@ -0,0 +1,179 @@
+package cssmodules
+
+import (
+	"bytes"
+
+	css_parser "github.com/tdewolff/parse/v2/css"
+)
+
+// cssRole is the role of a token found by a cssContext.
+type cssRole int
+
+const (
+	roleNone cssRole = iota
+	// The hash token is an ID selector
+	roleIDSelector
+	// The ident is the name of a `@keyframes` rule
+	roleKeyframesName
+	// The ident is a keyframes name in the value of an animation declaration
+	roleAnimationName
+)
+
+// cssContext tracks the blocks and declarations of a stylesheet, telling the
+// ID selectors from the colors and the keyframes names from the other idents.
+type cssContext struct {
+	// blocks are the kinds of the open blocks and nextBlock is the kind of the
+	// block opened by the current prelude
+	blocks    []int
+	nextBlock int
+	buf       [8]int
+
+	// declStart is set at the start of a declaration, name is the candidate
+	// name of the declaration until its colon and property its name after
+	// it. parens is the depth of the parentheses of its value
+	declStart bool
+	name      []byte
+	property  []byte
+	parens    int
+
+	// keyframes is set after a `@keyframes` keyword
+	keyframes bool
+
+	// atRule is set in the prelude of an at-rule, until its `{` or `;`
+	atRule bool
+}
+
+// reset makes the context start in a block of the kind given, blockRules for
+// stylesheets and blockDeclarations for style attributes.
+func (c *cssContext) reset(kind int) {
+	c.blocks = append(c.buf[:0], kind)
+	c.nextBlock = blockDeclarations
+	c.endDeclaration()
+	c.keyframes = false
+	c.atRule = false
+}
+
+func (c *cssContext) endDeclaration() {
+	c.declStart = c.blocks[len(c.blocks)-1] == blockDeclarations
+	c.name, c.property, c.parens = nil, nil, 0
+}
+
+// token updates the context with the next token, returning its role.
+func (c *cssContext) token(zt css_parser.TokenType, data []byte) cssRole {
+	switch zt {
+	case css_parser.WhitespaceToken, css_parser.CommentToken:
+		return roleNone
+	case css_parser.LeftBraceToken:
+		c.blocks = append(c.blocks, c.nextBlock)
+		c.nextBlock = blockDeclarations
+		c.keyframes = false
+		c.atRule = false
+		c.endDeclaration()
+		return roleNone
+	case css_parser.RightBraceToken:
+		if len(c.blocks) > 1 {
+			c.blocks = c.blocks[:len(c.blocks)-1]
+		}
+		c.nextBlock = blockDeclarations
+		c.atRule = false
+		c.endDeclaration()
+		return roleNone
+	case css_parser.SemicolonToken:
+		c.nextBlock = blockDeclarations
+		c.keyframes = false
+		c.atRule = false
+		c.endDeclaration()
+		return roleNone
+	case css_parser.AtKeywordToken:
+		c.declStart = false
+		c.atRule = c.blocks[len(c.blocks)-1] != blockDeclarations
+		switch string(data) {
+		case "@media", "@supports", "@container", "@layer", "@document", "@scope", "@starting-style":
+			c.nextBlock = blockRules
+		case "@keyframes", "@-webkit-keyframes", "@-moz-keyframes", "@-o-keyframes":
+			c.nextBlock = blockKeyframes
+			c.keyframes = true
+		}
+		return roleNone
+	}
+
+	if c.keyframes {
+		c.keyframes = false
+		if zt == css_parser.IdentToken {
+			return roleKeyframesName
+		}
+	}
+
+	switch c.blocks[len(c.blocks)-1] {
+	case blockRules:
+		// The hash tokens of the at-rule preludes are values, like the color
+		// of `@supports (color: #fff)`
+		if zt == css_parser.HashToken && !c.atRule {
+			return roleIDSelector
+		}
+	case blockDeclarations:
+		if c.declStart {
+			c.declStart = false
+			if zt == css_parser.IdentToken || zt == css_parser.CustomPropertyNameToken {
+				c.name = data
+				return roleNone
+			}
+		}
+		if zt == css_parser.ColonToken && c.name != nil {
+			c.property, c.name = c.name, nil
+			return roleNone
+		}
+		c.name = nil
+		if c.property == nil {
+			return roleNone
+		}
+		switch zt {
+		case css_parser.FunctionToken, css_parser.LeftParenthesisToken:
+			c.parens++
+		case css_parser.RightParenthesisToken:
+			c.parens--
+		case css_parser.IdentToken:
+			if c.parens == 0 && isAnimationProperty(c.property) && !isAnimationKeyword(data) {
+				return roleAnimationName
+			}
+		}
+	}
+	return roleNone
+}
+
+var (
+	animationProperty     = []byte("animation")
+	animationNameProperty = []byte("animation-name")
+)
+
+// isAnimationProperty reports if the property takes keyframes names, including
+// the vendor prefixed ones.
+func isAnimationProperty(property []byte) bool {
+	if len(property) != 0 && property[0] == '-' {
+		if i := bytes.IndexByte(property[1:], '-'); i != -1 {
+			property = property[i+2:]
+		}
+	}
+	return bytes.EqualFold(property, animationProperty) || bytes.EqualFold(property, animationNameProperty)
+}
+
+// animationKeywords are the keywords of the animation properties, the other
+// idents of their values are keyframes names.
+var animationKeywords = [][]byte{
+	[]byte("none"), []byte("infinite"), []byte("normal"), []byte("reverse"),
+	[]byte("alternate"), []byte("alternate-reverse"), []byte("forwards"),
+	[]byte("backwards"), []byte("both"), []byte("running"), []byte("paused"),
+	[]byte("ease"), []byte("ease-in"), []byte("ease-out"), []byte("ease-in-out"),
+	[]byte("linear"), []byte("step-start"), []byte("step-end"), []byte("auto"),
+	[]byte("initial"), []byte("inherit"), []byte("unset"), []byte("revert"),
+	[]byte("revert-layer"),
+}
+
+func isAnimationKeyword(ident []byte) bool {
+	for _, k := range animationKeywords {
+		if bytes.EqualFold(ident, k) {
+			return true
+		}
+	}
+	return false
+}
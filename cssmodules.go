@@ -1,217 +1,437 @@
 package cssmodules
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/binary"
+	"hash"
+	"hash/adler32"
 	"io"
-	"sync"
+	"strings"
 
 	"github.com/tdewolff/parse/v2"
 	css_parser "github.com/tdewolff/parse/v2/css"
 )
 
+// CSSModulesParser processes a stylesheet. It can process another stylesheet
+// after calling Reset, reusing the buffer the stylesheet is read into and the
+// scratch space of the processor, so high-throughput servers can keep the
+// parsers in a sync.Pool. The CSS lexer can't be reset, a new one is created
+// for every stylesheet.
 type CSSModulesParser struct {
 	r              io.Reader
+	cfg            *config
+	proc           *cssProcessor
+	input          bytes.Buffer
 	alreadyWritten bool
 }
 
-func NewCSSModulesParser(css io.Reader) *CSSModulesParser {
-	return &CSSModulesParser{r: css}
+func NewCSSModulesParser(css io.Reader, opts ...Option) *CSSModulesParser {
+	cfg := newConfig(opts)
+	return &CSSModulesParser{r: css, cfg: cfg, proc: newCSSProcessor(cfg)}
+}
+
+// Reset makes the parser process the stylesheet read from css in the next call
+// to ParseTo, keeping its options.
+func (p *CSSModulesParser) Reset(css io.Reader) {
+	p.r = css
+	p.alreadyWritten = false
 }
 
 func (p *CSSModulesParser) ParseTo(w io.Writer) (map[string]string, error) {
+	return p.ParseToContext(context.Background(), w)
+}
+
+// ParseToContext is like ParseTo but stops the processing when ctx is done,
+// returning a *PositionError wrapping ctx.Err().
+func (p *CSSModulesParser) ParseToContext(ctx context.Context, w io.Writer) (map[string]string, error) {
 	if p.alreadyWritten {
 		return nil, ErrAlreadyWritten
 	}
+	// The stylesheet is consumed even if the processing fails
+	p.alreadyWritten = true
+	p.input.Reset()
+	if _, err := p.input.ReadFrom(limitInput(p.r, p.cfg)); err != nil {
+		return nil, err
+	}
+	// The buffer always has room for the NULL byte appended by the lexer, so
+	// it's used without copying it
+	in := parse.NewInputBytes(p.input.Bytes())
 	if x, ok := w.(writer); ok {
-		return processCSSModules(p.r, x)
+		return p.proc.process(ctx, in, x)
 	}
-	buf := getBuffer()
-	defer releaseBuffer(buf)
-	classes, err := processCSSModules(p.r, buf)
+	// The output is streamed through a fixed size buffer
+	bw := getBufioWriter(w)
+	defer releaseBufioWriter(bw)
+	classes, err := p.proc.process(ctx, in, bw)
 	if err != nil {
 		return nil, err
 	}
-	if _, err := buf.WriteTo(w); err != nil {
+	if err := bw.Flush(); err != nil {
 		return nil, err
 	}
-	p.alreadyWritten = true
 	return classes, nil
 }
 
 // Parses the CSS and returns the CSS processed, the key-value pair of the
 // classes and scoped classes, and an error if there is one
-func ProcessCSSModules(css io.Reader) ([]byte, map[string]string, error) {
+func ProcessCSSModules(css io.Reader, opts ...Option) ([]byte, map[string]string, error) {
+	return ProcessCSSModulesContext(context.Background(), css, opts...)
+}
 
-	bb := getBuffer()
-	defer releaseBuffer(bb)
+// ProcessCSSModulesContext is like ProcessCSSModules but stops the processing
+// when ctx is done, returning a *PositionError wrapping ctx.Err().
+func ProcessCSSModulesContext(ctx context.Context, css io.Reader, opts ...Option) ([]byte, map[string]string, error) {
+	cfg := newConfig(opts)
+	in := newInput(css, cfg)
 
-	scopedClasses, err := processCSSModules(css, bb)
+	// The scoped classes are a few bytes longer than the classes, so the output
+	// is allocated once in most cases and returned without copying it
+	out := bytes.NewBuffer(make([]byte, 0, in.Len()+in.Len()/2))
+
+	scopedClasses, err := newCSSProcessor(cfg).process(ctx, in, out)
 	if err != nil {
 		return nil, nil, err
 	}
-	cpBb := make([]byte, bb.Len())
-	if _, err := bb.Read(cpBb); err != nil {
-		return nil, nil, err
-	}
-	return cpBb, scopedClasses, nil
+	return out.Bytes(), scopedClasses, nil
 }
 
-func processCSSModules(r io.Reader, w writer) (map[string]string, error) {
-	zz := css_parser.NewLexer(parse.NewInput(r))
-	scopedClasses := map[string]string{}
+// cssProcessor holds the state needed for processing a stylesheet. The state is
+// reused for every class of the stylesheet, so each unique class is hashed only
+// once and scoping it again doesn't allocate.
+type cssProcessor struct {
+	cfg    *config
+	ctx    context.Context
+	w      writer
+	ew     errWriter
+	in     *parse.Input
+	zz     *css_parser.Lexer
+	hasher hash.Hash32
+	salt   [4]byte
 
-	mutex := &sync.Mutex{}
+	// memo maps the literal names of the classes to their scoped names, it's
+	// the same map as scopedClasses unless the keys of it are converted
+	memo          map[string]string
+	scopedClasses map[string]string
 
-	salt := make([]byte, 4)
-	if _, err := rand.Read(salt); err != nil {
+	// keyBuf is scratch space for the keys of the keyframes
+	keyBuf []byte
+
+	// localSelector is set by a `:local` pseudo-class and lasts until the end
+	// of the current selector
+	localSelector bool
+
+	// css tracks the blocks and declarations of the stylesheet and role is the
+	// role of the last token in it
+	css  cssContext
+	role cssRole
+
+	// err is set when the processing is stopped before the end of the input
+	err error
+}
+
+func newCSSProcessor(cfg *config) *cssProcessor {
+	// Every processor has its own hasher so concurrent calls don't race on it
+	return &cssProcessor{cfg: cfg, hasher: adler32.New()}
+}
+
+// process writes the CSS processed to w and returns the key-value pair of the
+// classes and scoped classes. Every call uses a new salt.
+func (p *cssProcessor) process(ctx context.Context, in *parse.Input, w writer) (map[string]string, error) {
+	if _, err := rand.Read(p.salt[:]); err != nil {
 		return nil, err
 	}
-	dataTempBuffer := getBuffer()
-	defer releaseBuffer(dataTempBuffer)
-mainLoop:
+	if p.cfg.safeMode != nil {
+		sanitized := getBuffer()
+		defer releaseBuffer(sanitized)
+		if err := sanitizeCSS(ctx, p.cfg.safeMode, in, sanitized); err != nil {
+			return nil, err
+		}
+		in = parse.NewInputBytes(sanitized.Bytes())
+	}
+	p.ctx = ctx
+	p.ew = errWriter{w: w}
+	p.w = &p.ew
+	p.in = in
+	p.zz = css_parser.NewLexer(in)
+	p.scopedClasses = map[string]string{}
+	p.memo = p.scopedClasses
+	if lc := p.cfg.localsConvention; lc == LocalsCamelCaseOnly || lc == LocalsDashesOnly {
+		p.memo = map[string]string{}
+	}
+	p.localSelector = false
+	p.css.reset(blockRules)
+	p.err = nil
+	defer func() {
+		p.ctx = nil
+		p.w = nil
+		p.ew = errWriter{}
+		p.in = nil
+		p.zz = nil
+	}()
+
 	for {
-		zt, data := zz.Next()
+		zt, data := p.next()
 		if zt == css_parser.ErrorToken {
-			if err := zz.Err(); err == io.EOF {
-				return scopedClasses, nil
-			} else if err != nil {
+			if p.err != nil {
+				return nil, p.err
+			}
+			if err := p.zz.Err(); err != io.EOF {
 				return nil, err
 			}
+			return p.scopedClasses, nil
 		}
+		p.processToken(zt, data)
+	}
+}
 
-		dataTempBuffer.Write(data)
+// next returns the next token of the stylesheet, or an ErrorToken setting
+// p.err if the context is done or writing the output failed.
+func (p *cssProcessor) next() (css_parser.TokenType, []byte) {
+	if p.err != nil {
+		return css_parser.ErrorToken, nil
+	}
+	if p.ew.err != nil {
+		p.err = p.ew.err
+		return css_parser.ErrorToken, nil
+	}
+	if err := contextErr(p.ctx); err != nil {
+		p.err = &PositionError{Pos: positionOf(p.in.Bytes(), p.in.Offset()), Err: err}
+		return css_parser.ErrorToken, nil
+	}
+	zt, data := p.zz.Next()
+	p.role = p.css.token(zt, data)
+	return zt, data
+}
 
-		if zt == css_parser.ColonToken {
-			zt, data := zz.Next()
-			if zt == css_parser.ErrorToken {
-				continue mainLoop
-			}
-			if zt != css_parser.IdentToken {
-				dataTempBuffer.WriteTo(w)
-				w.Write(data)
-				continue mainLoop
-			}
-			if string(data) != "global" {
-				dataTempBuffer.WriteTo(w)
-				w.Write(data)
-				continue mainLoop
-			}
-			braceCount := 0
-			for {
-				zt, data := zz.Next()
-				if zt == css_parser.ErrorToken {
-					continue mainLoop
-				}
-				if zt == css_parser.LeftBraceToken {
-					if braceCount != 0 {
-						w.Write(data)
-					}
-					braceCount++
-				} else if zt == css_parser.RightBraceToken {
-					if braceCount != 1 {
-						w.Write(data)
-					}
-					braceCount--
-					if braceCount <= 0 {
-						break
-					}
-				} else {
-					w.Write(data)
-				}
-			}
-		} else if zt == css_parser.AtKeywordToken {
-			if _, err := dataTempBuffer.WriteTo(w); err != nil {
-				return nil, err
-			}
-			zt, data := zz.Next()
-			if zt == css_parser.ErrorToken {
-				continue mainLoop
-			}
-			w.Write(data)
-			if zt != css_parser.IdentToken {
-				continue mainLoop
-			}
-			if string(data) != "media" {
-				continue mainLoop
+func (p *cssProcessor) processToken(zt css_parser.TokenType, data []byte) {
+	switch zt {
+	case css_parser.ColonToken:
+		p.processColon(data)
+	case css_parser.DelimToken:
+		p.w.Write(data)
+		if string(data) == "." {
+			p.processClass(p.cfg.mode == ModeLocal || p.localSelector)
+		}
+	case css_parser.LeftBraceToken, css_parser.CommaToken:
+		p.localSelector = false
+		p.w.Write(data)
+	case css_parser.HashToken:
+		if p.cfg.idScoping && p.role == roleIDSelector && (p.cfg.mode == ModeLocal || p.localSelector) {
+			p.scopeID(data)
+		} else {
+			p.w.Write(data)
+		}
+	case css_parser.IdentToken:
+		if p.cfg.keyframesScoping && p.cfg.mode == ModeLocal && (p.role == roleKeyframesName || p.role == roleAnimationName) {
+			p.scopeKeyframes(data)
+		} else {
+			p.w.Write(data)
+		}
+	case css_parser.CustomPropertyNameToken:
+		if p.cfg.customPropertiesScoping && p.cfg.mode == ModeLocal {
+			p.scopeCustomProperty(data)
+		} else {
+			p.w.Write(data)
+		}
+	case css_parser.URLToken:
+		if p.cfg.idScoping && (p.cfg.mode == ModeLocal || p.localSelector) {
+			p.scopeURL(data)
+		} else {
+			p.w.Write(data)
+		}
+	default:
+		p.w.Write(data)
+	}
+}
+
+// processClass processes the token following a `.` delimiter, scoping it if
+// it's a class name and scope is true.
+func (p *cssProcessor) processClass(scope bool) {
+	zt, data := p.next()
+	if zt == css_parser.IdentToken {
+		if scope {
+			p.scopeClass(data)
+		} else {
+			p.w.Write(data)
+		}
+		return
+	}
+	if zt != css_parser.ErrorToken {
+		p.processToken(zt, data)
+	}
+}
+
+// processColon processes the pseudo-class following colon, handling the
+// `:local` and `:global` ones.
+func (p *cssProcessor) processColon(colon []byte) {
+	zt, data := p.next()
+	switch {
+	case zt == css_parser.FunctionToken && (string(data) == "local(" || string(data) == "global("):
+		p.processSelectorFunction(string(data) == "local(")
+	case zt == css_parser.IdentToken && string(data) == "local":
+		p.localSelector = true
+	case zt == css_parser.IdentToken && string(data) == "global":
+		p.processGlobalBlock()
+	default:
+		p.w.Write(colon)
+		if zt != css_parser.ErrorToken {
+			p.processToken(zt, data)
+		}
+	}
+}
+
+// processGlobalBlock writes the contents of a `:global` block without scoping
+// them and without the braces delimiting the block.
+func (p *cssProcessor) processGlobalBlock() {
+	braceCount := 0
+	for {
+		zt, data := p.next()
+		if zt == css_parser.ErrorToken {
+			return
+		}
+		if zt == css_parser.LeftBraceToken {
+			if braceCount != 0 {
+				p.w.Write(data)
 			}
-			for {
-				zt, data := zz.Next()
-				if zt == css_parser.ErrorToken {
-					continue mainLoop
-				}
-
-				w.Write(data)
-
-				braceCount := 0
-				if zt == css_parser.DelimToken && string(data) == "." {
-					zt, data := zz.Next()
-					if zt == css_parser.ErrorToken {
-						continue mainLoop
-					}
-					if zt != css_parser.IdentToken {
-						w.Write(data)
-						continue
-					}
-					scopeCSSClass(data, salt, w, scopedClasses, mutex)
-				} else if zt == css_parser.LeftBraceToken {
-					braceCount++
-				} else if zt == css_parser.RightBraceToken {
-					braceCount--
-					if braceCount <= 0 {
-						break
-					}
-				}
+			braceCount++
+		} else if zt == css_parser.RightBraceToken {
+			if braceCount != 1 {
+				p.w.Write(data)
 			}
-		} else if zt == css_parser.DelimToken && string(data) == "." {
-			if _, err := dataTempBuffer.WriteTo(w); err != nil {
-				return nil, err
+			braceCount--
+			if braceCount <= 0 {
+				return
 			}
-			zt, data := zz.Next()
+		} else {
+			p.w.Write(data)
+		}
+	}
+}
+
+// processSelectorFunction writes the arguments of a `:local(` or `:global(`
+// selector without the wrapping function, scoping the classes inside of it only
+// if local is true.
+func (p *cssProcessor) processSelectorFunction(local bool) {
+	parenCount := 1
+	for {
+		zt, data := p.next()
+		if zt == css_parser.ErrorToken {
+			return
+		}
+		if local && zt == css_parser.HashToken && p.cfg.idScoping {
+			p.scopeID(data)
+			continue
+		}
+		if local && zt == css_parser.DelimToken && string(data) == "." {
+			p.w.Write(data)
+			zt, data = p.next()
 			if zt == css_parser.ErrorToken {
-				continue mainLoop
+				return
 			}
-			if zt != css_parser.IdentToken {
-				w.Write(data)
-				continue mainLoop
+			if zt == css_parser.IdentToken {
+				p.scopeClass(data)
+				continue
 			}
-			scopeCSSClass(data, salt, w, scopedClasses, mutex)
-		} else {
-			if _, err := dataTempBuffer.WriteTo(w); err != nil {
-				return nil, err
+		}
+		if zt == css_parser.FunctionToken || zt == css_parser.LeftParenthesisToken {
+			parenCount++
+		} else if zt == css_parser.RightParenthesisToken {
+			parenCount--
+			if parenCount <= 0 {
+				return
 			}
 		}
-		dataTempBuffer.Reset()
+		p.w.Write(data)
 	}
 }
 
-func scopeCSSClass(data []byte, salt []byte, w writer, scopedClasses map[string]string, mutex *sync.Mutex) {
-	defer adlerHashFunction.Reset()
-	defer mutex.Unlock()
-	mutex.Lock()
-	adlerHashFunction.Write(data)
-	adlerHashFunction.Write(salt)
+// scopeClass writes the scoped name of the class to w, hashing the class only
+// the first time it's found in the stylesheet.
+func (p *cssProcessor) scopeClass(data []byte) {
+	if scoped, ok := p.memo[string(data)]; ok {
+		p.w.WriteString(scoped)
+		return
+	}
+
+	scoped := p.scopedName(data)
+	p.w.WriteString(scoped)
 
-	checksum := adlerHashFunction.Sum32()
+	// The name shares its memory with the scoped name
+	name := scoped[1 : 1+len(data)]
+	p.memo[name] = scoped
+	key, altKey := p.cfg.localsConvention.keys(name)
+	p.scopedClasses[key] = scoped
+	if altKey != "" {
+		p.scopedClasses[altKey] = scoped
+	}
+}
 
-	bufChecksumUint32 := make([]byte, 4)
-	binary.NativeEndian.PutUint32(bufChecksumUint32, checksum)
+// scopedName returns the scoped name of a class, ID or keyframes,
+// `_name_hash`.
+func (p *cssProcessor) scopedName(data []byte) string {
+	p.hasher.Reset()
+	p.hasher.Write(data)
+	p.hasher.Write(p.salt[:])
 
-	encodedChecksum := base64.RawURLEncoding.EncodeToString(bufChecksumUint32)
+	var checksum [4]byte
+	binary.NativeEndian.PutUint32(checksum[:], p.hasher.Sum32())
 
-	tempBuffer := getBuffer()
-	defer releaseBuffer(tempBuffer)
+	var encodedChecksum [6]byte
+	base64.RawURLEncoding.Encode(encodedChecksum[:], checksum[:])
 
-	tempBuffer.WriteByte('_')
-	tempBuffer.Write(data)
-	tempBuffer.WriteByte('_')
-	tempBuffer.WriteString(encodedChecksum)
+	sb := strings.Builder{}
+	sb.Grow(len(data) + len(encodedChecksum) + 2)
+	sb.WriteByte('_')
+	sb.Write(data)
+	sb.WriteByte('_')
+	sb.Write(encodedChecksum[:])
+	return sb.String()
+}
 
-	bufScopedClassName := make([]byte, tempBuffer.Len())
-	copy(bufScopedClassName, tempBuffer.Bytes())
+// scopeID writes the hash token of an ID with the ID scoped. The scoped IDs are
+// kept under the key `#id`, without the key conventions.
+func (p *cssProcessor) scopeID(data []byte) {
+	p.w.WriteByte('#')
+	if scoped, ok := p.scopedClasses[string(data)]; ok {
+		p.w.WriteString(scoped)
+		return
+	}
+	scoped := p.scopedName(data[1:])
+	p.w.WriteString(scoped)
+	p.scopedClasses[string(data)] = scoped
+}
 
-	tempBuffer.WriteTo(w)
+// scopeURL writes a url token, scoping the ID if it references one as in
+// `url(#gradient)`.
+func (p *cssProcessor) scopeURL(data []byte) {
+	if len(data) < len("url()") || data[len(data)-1] != ')' {
+		p.w.Write(data)
+		return
+	}
+	ref := data[len("url(") : len(data)-1]
+	start, end := 0, len(ref)
+	for start < end && isCSSSpace(ref[start]) {
+		start++
+	}
+	for end > start && isCSSSpace(ref[end-1]) {
+		end--
+	}
+	if end-start > 2 && (ref[start] == '"' || ref[start] == '\'') && ref[end-1] == ref[start] {
+		start++
+		end--
+	}
+	if end-start < 2 || ref[start] != '#' || bytes.ContainsAny(ref[start:end], "\\ \t\n") {
+		p.w.Write(data)
+		return
+	}
+	p.w.Write(data[:len("url(")+start])
+	p.scopeID(ref[start:end])
+	p.w.Write(data[len("url(")+end:])
+}
 
-	scopedClasses[string(data)] = string(bufScopedClassName)
+func isCSSSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
 }
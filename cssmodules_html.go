@@ -2,145 +2,603 @@ package cssmodules
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 
 	html_parser "golang.org/x/net/html"
 )
 
+// HTMLCSSModulesParser processes an HTML document. It can process another
+// document after calling Reset, reusing the scratch space of the processor, so
+// high-throughput servers can keep the parsers in a sync.Pool. The tokenizer of
+// x/net/html can't be reset, a new one is created for every document.
 type HTMLCSSModulesParser struct {
 	r              io.Reader
 	sc             map[string]string
+	cfg            *config
+	proc           *htmlProcessor
 	alreadyWritten bool
 }
 
-func NewHTMLCSSModulesParser(html io.Reader, scopedClasses map[string]string) *HTMLCSSModulesParser {
+// NewHTMLCSSModulesParser returns a parser processing the document read from
+// html with the scoped classes. When WithResolver is used too, the classes not
+// found in the scoped classes are resolved with the resolver.
+func NewHTMLCSSModulesParser(html io.Reader, scopedClasses map[string]string, opts ...Option) *HTMLCSSModulesParser {
+	cfg := newConfig(opts)
 	return &HTMLCSSModulesParser{
-		r:  html,
-		sc: scopedClasses,
+		r:    html,
+		sc:   scopedClasses,
+		cfg:  cfg,
+		proc: newHTMLProcessor(cfg),
 	}
 }
 
+// Reset makes the parser process the document read from html with the scoped
+// classes in the next call to ParseTo, keeping its options.
+func (p *HTMLCSSModulesParser) Reset(html io.Reader, scopedClasses map[string]string) {
+	p.r = html
+	p.sc = scopedClasses
+	p.alreadyWritten = false
+}
+
 func (p *HTMLCSSModulesParser) ParseTo(w io.Writer) error {
+	return p.ParseToContext(context.Background(), w)
+}
+
+// ParseToContext is like ParseTo but stops the processing when ctx is done,
+// returning a *PositionError wrapping ctx.Err().
+func (p *HTMLCSSModulesParser) ParseToContext(ctx context.Context, w io.Writer) error {
 	if p.alreadyWritten {
 		return ErrAlreadyWritten
 	}
+	// The document is consumed even if the processing fails
+	p.alreadyWritten = true
 	if x, ok := w.(writer); ok {
-		return parseHTMLWithCSSModules(p.r, x, p.sc)
+		return p.proc.process(ctx, p.r, x, p.sc)
 	}
-	buf := getBuffer()
-	defer releaseBuffer(buf)
-	if err := parseHTMLWithCSSModules(p.r, buf, p.sc); err != nil {
+	// The output is streamed through a fixed size buffer
+	bw := getBufioWriter(w)
+	defer releaseBufioWriter(bw)
+	err := p.proc.process(ctx, p.r, bw, p.sc)
+	var missingErr *MissingClassesError
+	if err != nil && !errors.As(err, &missingErr) {
 		return err
 	}
-	if _, err := buf.WriteTo(w); err != nil {
+	if err := bw.Flush(); err != nil {
 		return err
 	}
-	p.alreadyWritten = true
-	return nil
+	return err
 }
 
-func ProcessHTMLWithCSSModules(html io.Reader, scopedClasses map[string]string) ([]byte, error) {
+func ProcessHTMLWithCSSModules(html io.Reader, scopedClasses map[string]string, opts ...Option) ([]byte, error) {
+	return ProcessHTMLWithCSSModulesContext(context.Background(), html, scopedClasses, opts...)
+}
+
+// ProcessHTMLWithCSSModulesContext is like ProcessHTMLWithCSSModules but stops
+// the processing when ctx is done, returning a *PositionError wrapping
+// ctx.Err().
+func ProcessHTMLWithCSSModulesContext(ctx context.Context, html io.Reader, scopedClasses map[string]string, opts ...Option) ([]byte, error) {
 	buf := getBuffer()
 	defer releaseBuffer(buf)
-	if err := parseHTMLWithCSSModules(html, buf, scopedClasses); err != nil {
+	// The output is returned along with a *MissingClassesError
+	err := newHTMLProcessor(newConfig(opts)).process(ctx, html, buf, scopedClasses)
+	var missingErr *MissingClassesError
+	if err != nil && !errors.As(err, &missingErr) {
 		return nil, err
 	}
 	cpBuf := make([]byte, buf.Len())
-	if _, err := buf.Read(cpBuf); err != nil {
-		return nil, err
-	}
-	return cpBuf, nil
+	copy(cpBuf, buf.Bytes())
+	return cpBuf, err
+}
+
+// htmlProcessor holds the state needed for processing an HTML document, reused
+// between the tags of the document and between documents.
+type htmlProcessor struct {
+	cfg *config
+	w   writer
+	ew  errWriter
+
+	// base resolves the classes outside of the `css-module-scope` elements
+	base ClassResolver
+
+	// scopes are the elements with a `css-module-scope` attribute that are open,
+	// resolver and alias are the module used for the tag being processed
+	scopes   []moduleScope
+	resolver ClassResolver
+	alias    string
+
+	// missing are the classes that couldn't be resolved in the document
+	missing []MissingClass
+
+	// foreign counts the open `<svg>` and `<math>` elements
+	foreign int
+
+	// styleBuf holds the scoped CSS of the `<style module>` elements of the
+	// document, styles are the ends of each one in styleBuf and style is the
+	// next one. skipStyleText is set after writing one in place of the text
+	// of the element
+	styleBuf      []byte
+	styles        []int
+	style         int
+	skipStyleText bool
+
+	// links are the paths of the assets of the `<link>` modules of the
+	// document and link is the next one
+	links []string
+	link  int
+
+	// pos is the position of the tag being processed, tag, attrs and names are scratch space for the tag being processed,
+	// the classes are escaped
+	pos   Position
+	tag   []byte
+	attrs []htmlAttr
+	names []string
+
+	// refs are the values replacing the values of the attributes referencing
+	// scoped IDs or rewritten, idBuf is scratch space for the lookups
+	refs  []string
+	idBuf []byte
+
+	// css tracks the CSS rewritten, rewriteStyleText is set after a
+	// `<style css-module-style>` tag for rewriting the text of the element
+	css              cssContext
+	rewriteStyleText bool
+
+	// compiled is the document being compiled by CompileHTML, written to out
+	compiled *CompiledHTML
+	out      *bytes.Buffer
+
+	// targets and seen are scratch space for merging the classes
+	targets []string
+	seen    []string
 }
 
-func parseHTMLWithCSSModules(r io.Reader, w writer, scopedClasses map[string]string) error {
+// moduleScope is an element setting the default module of its subtree. depth
+// counts the open elements with the same name, so the scope ends at the end
+// tag matching the element.
+type moduleScope struct {
+	name     []byte
+	resolver ClassResolver
+	alias    string
+	depth    int
+}
+
+func newHTMLProcessor(cfg *config) *htmlProcessor {
+	return &htmlProcessor{cfg: cfg}
+}
+
+// process writes the HTML read from r to w, only rewriting the tags that have
+// a source attribute. Everything else is copied byte for byte.
+func (p *htmlProcessor) process(ctx context.Context, r io.Reader, w writer, scopedClasses map[string]string) error {
+	p.ew = errWriter{w: w}
+	p.w = &p.ew
+	p.scopes = p.scopes[:0]
+	p.missing = p.missing[:0]
+	defer func() {
+		p.w = nil
+		p.ew = errWriter{}
+		p.base = nil
+		p.resolver = nil
+		for i := range p.scopes {
+			p.scopes[i].resolver = nil
+		}
+	}()
+
+	p.styles = p.styles[:0]
+	p.links = p.links[:0]
+	p.skipStyleText = false
+	p.rewriteStyleText = false
+	if p.cfg.styleModules || p.cfg.linkFS != nil {
+		doc := getBuffer()
+		defer releaseBuffer(doc)
+		if _, err := doc.ReadFrom(r); err != nil {
+			return err
+		}
+		cfg, err := p.scanModules(ctx, doc.Bytes(), scopedClasses)
+		if err != nil {
+			return err
+		}
+		if cfg != nil {
+			base := p.cfg
+			p.cfg = cfg
+			defer func() {
+				p.cfg = base
+			}()
+		}
+		r = bytes.NewReader(doc.Bytes())
+	}
+
+	p.base = p.cfg.resolverFor(scopedClasses)
 
 	zz := html_parser.NewTokenizer(r)
+	zz.AllowCDATA(p.cfg.xml)
+	p.foreign = 0
+
+	pos := Position{Line: 1, Column: 1}
 
-mainLoop:
 	for {
+		// Raw returns the previous token until Next is called
+		pos.advance(zz.Raw())
+		if err := contextErr(ctx); err != nil {
+			return &PositionError{Pos: pos, Err: err}
+		}
+		// The rest of the document isn't read once writing the output failed
+		if p.ew.err != nil {
+			return p.ew.err
+		}
+
 		zt := zz.Next()
 		if err := zz.Err(); err == io.EOF {
-			return nil
+			return p.missingErr()
 		} else if err != nil {
 			return err
 		}
 
-		// Exclude tokens that doesn't have attributes and writes to w
+		if p.skipStyleText {
+			p.skipStyleText = false
+			if zt == html_parser.TextToken {
+				continue
+			}
+		}
+		if p.rewriteStyleText {
+			p.rewriteStyleText = false
+			if zt == html_parser.TextToken {
+				if css := p.rewriteCSS(zz.Raw(), blockRules); css != "" {
+					p.w.WriteString(css)
+					continue
+				}
+			}
+		}
+
+		// Only tags can have attributes. TagName and TagAttr are never called
+		// because they modify the raw bytes of the tag
 		if zt != html_parser.StartTagToken && zt != html_parser.SelfClosingTagToken {
-			w.Write(zz.Raw())
+			if zt == html_parser.EndTagToken && len(p.scopes) != 0 {
+				p.closeScope(zz.Raw())
+			}
+			if zt == html_parser.EndTagToken && p.foreign != 0 && isForeignRoot(endTagName(zz.Raw())) {
+				p.foreign--
+				zz.AllowCDATA(p.cfg.xml || p.foreign != 0)
+			}
+			p.w.Write(zz.Raw())
+			continue
+		}
+		raw := zz.Raw()
+		p.pos = pos
+		if len(p.styles) != 0 && zt == html_parser.StartTagToken {
+			if ok, err := p.processStyleModule(raw); err != nil {
+				return err
+			} else if ok {
+				continue
+			}
+		}
+		if len(p.links) != 0 && p.processLinkModule(raw) {
 			continue
 		}
+		if hasUnclosedAction(raw, &p.cfg.delims) {
+			// The tokenizer ended the tag at a '>' inside of an action, the
+			// following tokens are read until the action is closed
+			p.tag = append(p.tag[:0], raw...)
+			for hasUnclosedAction(p.tag, &p.cfg.delims) {
+				pos.advance(zz.Raw())
+				if zz.Next() == html_parser.ErrorToken {
+					break
+				}
+				p.tag = append(p.tag, zz.Raw()...)
+			}
+			raw = p.tag
+		}
+		if zt == html_parser.StartTagToken {
+			// The content of the SVG and MathML elements follows the rules of
+			// XML, like a document in XML mode
+			if isForeignRoot(startTagName(raw)) {
+				p.foreign++
+				zz.AllowCDATA(true)
+			}
+			if p.cfg.xml || p.foreign != 0 {
+				zz.NextIsNotRawText()
+			}
+		}
+		if err := p.processTag(raw, zt == html_parser.SelfClosingTagToken); err != nil {
+			return err
+		}
+	}
+}
 
-		tagName, hasAttr := zz.TagName()
+// processTag writes the raw bytes of a start tag, removing its source and scope
+// attributes and merging the scoped classes into the target attribute. The
+// bytes following the end of the tag are written as they are.
+func (p *htmlProcessor) processTag(raw []byte, selfClosing bool) error {
+	nameEnd, attrs := scanTag(raw, p.attrs[:0], &p.cfg.delims)
+	p.attrs = attrs
 
-		// If the tag doesn't have attributes at all, then there is no need to read it.
-		// It's directly written to w
-		if !hasAttr {
-			w.Write(zz.Raw())
-			continue
+	firstSource, target, scope, marker := -1, -1, -1, -1
+	for i := range attrs {
+		key := attrs[i].key(raw)
+		switch {
+		case p.cfg.isSourceAttr(key):
+			if firstSource == -1 {
+				firstSource = i
+			}
+		case scope == -1 && p.cfg.equalName(key, scopeAttr):
+			scope = i
+		case marker == -1 && p.cfg.styleRewriting && p.cfg.equalName(key, styleMarkerAttr):
+			marker = i
+		case target == -1 && p.cfg.isTargetAttr(key):
+			target = i
 		}
+	}
 
-		w.WriteByte('<')
-		w.Write(tagName)
+	if err := p.enterTag(raw[1:nameEnd], raw, attrs, scope, selfClosing); err != nil {
+		return err
+	}
+
+	p.names = p.names[:0]
+	if firstSource != -1 {
+		for i := firstSource; i < len(attrs); i++ {
+			if !p.cfg.isSourceAttr(attrs[i].key(raw)) {
+				continue
+			}
+			if err := p.resolveClasses(raw, &attrs[i]); err != nil {
+				return err
+			}
+		}
+	}
 
-		var (
-			hasClassAttr bool
-			classVal     []byte
-		)
+	refs := (p.cfg.idScoping || marker != -1) && p.scopeReferences(raw, attrs, marker != -1)
+	if marker != -1 && !selfClosing && bytes.EqualFold(raw[1:nameEnd], styleTag) {
+		p.rewriteStyleText = true
+	}
 
-		var (
-			cssModulesVal []byte
-		)
+	// Tags without source, scope and marker attributes are written as they are
+	if firstSource == -1 && scope == -1 && marker == -1 && !refs {
+		p.w.Write(raw)
+		return nil
+	}
 
-		for {
-			tagAttrKey, tagAttrVal, hasMoreAttr := zz.TagAttr()
-			if string(tagAttrKey) == "css-module" {
-				cssModulesVal = tagAttrVal
-			} else if string(tagAttrKey) == "class" {
-				hasClassAttr = true
-				classVal = tagAttrVal
-			} else {
-				w.WriteByte(' ')
-				w.Write(tagAttrKey)
-				w.WriteString(`="`)
-				w.WriteString(html_parser.EscapeString(string(tagAttrVal)))
-				w.WriteByte('"')
+	w := p.w
+	// written is the end of the raw bytes written, prev is the end of the
+	// previous attribute
+	written, prev := 0, nameEnd
+	for i := range attrs {
+		a := &attrs[i]
+		if refs && p.refs[i] != "" {
+			w.Write(raw[written:a.valStart])
+			w.WriteString(p.refs[i])
+			written = a.valEnd
+		} else if i == target && firstSource != -1 {
+			quote := a.quote
+			if quote == 0 {
+				quote = '"'
 			}
-			if !hasMoreAttr {
-				if cssModulesVal == nil {
-					if !hasClassAttr {
-						w.WriteByte('>')
-						continue mainLoop
-					}
-					w.WriteString(` class="`)
-					w.WriteString(html_parser.EscapeString(string(bytes.TrimSpace(classVal))))
-					w.WriteString(`">`)
-					continue mainLoop
-				}
-				w.WriteString(` class="`)
-				if hasClassAttr {
-					w.WriteString(html_parser.EscapeString(string(bytes.TrimSpace(classVal))))
+			w.Write(raw[written:a.start])
+			w.Write(a.key(raw))
+			w.WriteByte('=')
+			w.WriteByte(quote)
+			p.writeClasses(bytes.TrimSpace(a.val(raw)))
+			w.WriteByte(quote)
+			written = a.end
+		} else if i == scope || i == marker || p.cfg.isSourceAttr(a.key(raw)) {
+			keep := p.cfg.merge.KeepSource && i != scope && i != marker
+			if i == firstSource && target == -1 {
+				// The target attribute takes the place of the first source
+				// attribute, or follows it if it's kept
+				if keep {
+					w.Write(raw[written:a.end])
 					w.WriteByte(' ')
+				} else {
+					w.Write(raw[written:a.start])
 				}
-				break
+				w.WriteString(p.cfg.targetAttr)
+				w.WriteString(`="`)
+				p.writeClasses(nil)
+				w.WriteByte('"')
+			} else if keep {
+				w.Write(raw[written:a.end])
+			} else {
+				// The attribute is removed along with the whitespace before it
+				w.Write(raw[written:prev])
 			}
+			written = a.end
 		}
+		prev = a.end
+	}
+	w.Write(raw[written:])
+	return nil
+}
 
-		classes := bytes.Split(cssModulesVal, []byte{' '})
-		for i, c := range classes {
-			// If equals empty then ignore the consumer's HTML syntax error and continue
-			if bytes.Equal(c, nil) {
-				continue
-			}
-			class, exists := scopedClasses[string(c)]
-			if !exists {
-				return ErrClassNotFound
+// resolveClasses appends the scoped classes of the classes in the value of the
+// source attribute a to p.names, escaped for an attribute value.
+func (p *htmlProcessor) resolveClasses(raw []byte, a *htmlAttr) error {
+	val := a.val(raw)
+	if p.cfg.dynamicClasses && len(p.cfg.delims.left) != 0 && bytes.Contains(val, p.cfg.delims.left) {
+		classes, err := p.compileDynamicClasses(raw, a)
+		if err != nil {
+			return err
+		}
+		if classes != "" {
+			p.names = append(p.names, classes)
+		}
+		return nil
+	}
+	// offsets are the offsets of the classes in raw, unless the value is
+	// unescaped
+	offset, escaped := a.valStart, false
+	if bytes.IndexByte(val, '&') != -1 {
+		val, escaped = []byte(html_parser.UnescapeString(string(val))), true
+	}
+	for len(val) != 0 {
+		c := val
+		if i := p.cfg.indexSeparator(val); i != -1 {
+			c, val = val[:i], val[i+1:]
+		} else {
+			val = nil
+		}
+		cOffset := offset
+		if !escaped {
+			offset += len(c) + 1
+		}
+		// If equals empty then ignore the consumer's HTML syntax error and continue
+		if len(c) == 0 {
+			continue
+		}
+		if p.compiled != nil {
+			p.compiled.refs = append(p.compiled.refs, classRef{name: bytes.Clone(c), pos: p.positionOf(raw, cOffset)})
+			continue
+		}
+		n := len(p.names)
+		var err error
+		if p.names, err = p.cfg.appendClasses(p.names, p.resolver, c); err != nil {
+			class, err := p.missingClass(c, p.positionOf(raw, cOffset), err)
+			if err != nil {
+				return err
 			}
-			if i != 0 {
-				w.WriteByte(' ')
+			p.appendMissing(class)
+			continue
+		}
+		for i := n; i < len(p.names); i++ {
+			p.names[i] = html_parser.EscapeString(p.names[i])
+		}
+	}
+	return nil
+}
+
+// appendMissing appends the class written in place of a missing class to
+// p.names, writing the placeholder class once per tag.
+func (p *htmlProcessor) appendMissing(class string) {
+	if class == "" {
+		return
+	}
+	if p.cfg.missingPolicy == MissingClassPlaceholder {
+		for _, name := range p.names {
+			if name == class {
+				return
 			}
-			w.WriteString(class)
 		}
-		w.WriteString(`">`)
 	}
+	p.names = append(p.names, class)
+}
+
+// positionOf returns the position of the offset in the raw bytes of the tag
+// being processed.
+func (p *htmlProcessor) positionOf(raw []byte, offset int) Position {
+	pos := p.pos
+	pos.advance(raw[:offset])
+	return pos
+}
+
+// writeClasses writes the classes of the target attribute val merged with
+// p.names. When compiling the document it adds a slot for the scoped classes
+// instead.
+func (p *htmlProcessor) writeClasses(val []byte) {
+	if p.compiled == nil {
+		p.mergeClasses(p.w, val)
+		return
+	}
+	var target []string
+	if p.cfg.merge.rewritesTarget() {
+		target = targetClasses(nil, val)
+	} else {
+		p.w.Write(val)
+	}
+	p.compiled.addSlot(p.out.Len(), len(val) != 0 && target == nil, target, p.alias, p.resolver)
+}
+
+// scopeAttr is the attribute setting the default module of an element and its
+// descendants
+var scopeAttr = []byte("css-module-scope")
+
+// voidElements are the elements without end tag
+var voidElements = [][]byte{
+	[]byte("area"), []byte("base"), []byte("br"), []byte("col"), []byte("embed"),
+	[]byte("hr"), []byte("img"), []byte("input"), []byte("link"), []byte("meta"),
+	[]byte("source"), []byte("track"), []byte("wbr"),
+}
+
+func isVoidElement(name []byte) bool {
+	for _, v := range voidElements {
+		if bytes.EqualFold(name, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// enterTag sets the module used for the classes of the start tag, opening a
+// scope if the tag has a scope attribute and can have descendants.
+func (p *htmlProcessor) enterTag(name, raw []byte, attrs []htmlAttr, scope int, selfClosing bool) error {
+	p.resolver, p.alias = p.base, p.cfg.alias
+	if len(p.scopes) != 0 {
+		top := &p.scopes[len(p.scopes)-1]
+		p.resolver, p.alias = top.resolver, top.alias
+		if scope == -1 && !selfClosing && p.cfg.equalName(name, top.name) {
+			top.depth++
+		}
+	}
+	if scope == -1 {
+		return nil
+	}
+
+	alias := string(attrs[scope].val(raw))
+	module, ok := p.cfg.modules[alias]
+	if !ok {
+		return ErrModuleNotFound
+	}
+	p.resolver, p.alias = module, alias
+	if !selfClosing && (p.cfg.xml || !isVoidElement(name)) {
+		// The names of the previous scopes are reused
+		if len(p.scopes) < cap(p.scopes) {
+			p.scopes = p.scopes[:len(p.scopes)+1]
+		} else {
+			p.scopes = append(p.scopes, moduleScope{})
+		}
+		s := &p.scopes[len(p.scopes)-1]
+		s.name = append(s.name[:0], name...)
+		s.resolver, s.alias, s.depth = module, alias, 1
+	}
+	return nil
+}
+
+// closeScope closes the innermost scope if raw is its matching end tag.
+func (p *htmlProcessor) closeScope(raw []byte) {
+	top := &p.scopes[len(p.scopes)-1]
+	if !p.cfg.equalName(endTagName(raw), top.name) {
+		return
+	}
+	top.depth--
+	if top.depth == 0 {
+		top.resolver = nil
+		p.scopes = p.scopes[:len(p.scopes)-1]
+	}
+}
+
+// startTagName returns the name of the start tag in raw.
+func startTagName(raw []byte) []byte {
+	return tagName(raw[min(1, len(raw)):])
+}
+
+// endTagName returns the name of the end tag in raw.
+func endTagName(raw []byte) []byte {
+	return tagName(raw[min(2, len(raw)):])
+}
+
+func tagName(name []byte) []byte {
+	for i, c := range name {
+		if isHTMLSpace(c) || c == '/' || c == '>' {
+			return name[:i]
+		}
+	}
+	return name
+}
+
+var (
+	svgTag  = []byte("svg")
+	mathTag = []byte("math")
+)
+
+// isForeignRoot reports if name is the name of an element starting foreign
+// content in HTML.
+func isForeignRoot(name []byte) bool {
+	return bytes.EqualFold(name, svgTag) || bytes.EqualFold(name, mathTag)
 }
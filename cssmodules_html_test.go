@@ -1,7 +1,11 @@
 package cssmodules
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -66,6 +70,34 @@ var testCasesHTMLCSSModules = []struct {
 </div>
 {{template "layouts/layout-foot"}}`,
 	},
+	{
+		name:              "ValidHTMLCSSModules_ByteFaithful",
+		cssModulesClasses: map[string]string{"test-1": "RAN_1", "test-2": "RAN_2"},
+		expectedError:     "",
+
+		expectedHTML: `<INPUT Type='checkbox' disabled class="RAN_1" data-x="a &amp; b"/>
+<Button class='btn RAN_1 RAN_2' onclick='alert("hi")' hidden>Button</Button>
+<img alt=unquoted class="RAN_2">
+<svg viewBox="0 0 10 10"><path class="RAN_1" d="M0 0"/></svg>`,
+
+		payload: `<INPUT Type='checkbox' disabled css-module="test-1" data-x="a &amp; b"/>
+<Button class=' btn  ' CSS-MODULE='test-1 test-2' onclick='alert("hi")' hidden>Button</Button>
+<img alt=unquoted css-module=test-2>
+<svg viewBox="0 0 10 10"><path css-module="test-1" d="M0 0"/></svg>`,
+	},
+	{
+		name:              "ValidHTMLCSSModules_GoTemplatesInAttributes",
+		cssModulesClasses: map[string]string{"test-1": "RAN_1", "test-2": "RAN_2"},
+		expectedError:     "",
+
+		expectedHTML: `<a href="{{.URL}}" title='{{printf "%q" .X}}' class="RAN_1" {{if .Hidden}}hidden{{end}}>Link</a>
+<div {{ if gt .A 1 }}data-a="{{ "a>b" }}"{{ end }} class="RAN_2" data-b={{ .B }}>Div</div>
+<p class="{{.Class}} RAN_1">Paragraph</p>`,
+
+		payload: `<a href="{{.URL}}" title='{{printf "%q" .X}}' css-module="test-1" {{if .Hidden}}hidden{{end}}>Link</a>
+<div {{ if gt .A 1 }}data-a="{{ "a>b" }}"{{ end }} css-module="test-2" data-b={{ .B }}>Div</div>
+<p class="{{.Class}}" css-module="test-1">Paragraph</p>`,
+	},
 }
 
 func TestProcessHTMLWithCSSModules(t *testing.T) {
@@ -114,3 +146,333 @@ func TestHTMLCSSModulesParser_ParseTo(t *testing.T) {
 		})
 	}
 }
+
+func TestProcessHTMLWithCSSModules_Concurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				_, scopedClasses, err := ProcessCSSModules(strings.NewReader(`.test-1{color:red} .test-2{color:blue}`))
+				if err != nil {
+					t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+					return
+				}
+				for _, tc := range testCasesHTMLCSSModules {
+					// Replace the test classes by the ones generated in this goroutine
+					expectedHTML := strings.NewReplacer("RAN_1", scopedClasses["test-1"], "RAN_2", scopedClasses["test-2"]).Replace(tc.expectedHTML)
+					resultingHTML, err := ProcessHTMLWithCSSModules(strings.NewReader(tc.payload), scopedClasses)
+					if err != nil {
+						t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+						return
+					}
+					if string(resultingHTML) != expectedHTML {
+						t.Errorf("unexpected html value: expected %s got %s", expectedHTML, resultingHTML)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestProcessHTMLWithCSSModulesContext(t *testing.T) {
+	payload := "<div css-module=\"test-1\">\n<p css-module=\"test-2\"></p></div>"
+	classes := map[string]string{"test-1": "RAN_1", "test-2": "RAN_2"}
+
+	// Cancelled before the <p> tag, the 3rd token
+	_, err := ProcessHTMLWithCSSModulesContext(newCancelAfterContext(2), strings.NewReader(payload), classes)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error value: expected %q got %v", context.Canceled, err)
+		return
+	}
+	var posErr *PositionError
+	if !errors.As(err, &posErr) {
+		t.Errorf("unexpected error type: expected *PositionError got %T", err)
+		return
+	}
+	if expected := (Position{Offset: 26, Line: 2, Column: 1}); posErr.Pos != expected {
+		t.Errorf("unexpected position value: expected %+v got %+v", expected, posErr.Pos)
+	}
+
+	if err := NewHTMLCSSModulesParser(strings.NewReader(payload), classes).ParseToContext(newCancelAfterContext(0), &bytes.Buffer{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error value: expected %q got %v", context.Canceled, err)
+	}
+}
+
+func TestHTMLCSSModulesParser_Reset(t *testing.T) {
+	p := NewHTMLCSSModulesParser(strings.NewReader(`<p css-module="test-1"></p>`), map[string]string{"test-1": "RAN_1"})
+	buf := &bytes.Buffer{}
+	if err := p.ParseTo(buf); err != nil {
+		t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+		return
+	}
+	if err := p.ParseTo(buf); err != ErrAlreadyWritten {
+		t.Errorf("unexpected error value: expected %q got %v", ErrAlreadyWritten, err)
+		return
+	}
+	for i := range testCasesHTMLCSSModules {
+		tc := testCasesHTMLCSSModules[i]
+		buf.Reset()
+		p.Reset(strings.NewReader(tc.payload), tc.cssModulesClasses)
+		if err := p.ParseTo(buf); err != nil {
+			t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+			return
+		}
+		if buf.String() != tc.expectedHTML {
+			t.Errorf("unexpected html value: expected %s got %s", tc.expectedHTML, buf.String())
+			return
+		}
+	}
+}
+
+var testCasesHTMLCSSModulesAttributes = []struct {
+	name         string
+	payload      string
+	opts         []Option
+	expectedHTML string
+}{
+	{
+		name:         "DataAttribute",
+		opts:         []Option{WithSourceAttributes("data-css-module")},
+		expectedHTML: `<div class="RAN_1" css-module="test-2"></div>`,
+
+		payload: `<div data-css-module="test-1" css-module="test-2"></div>`,
+	},
+	{
+		name:         "SeveralSourceAttributes",
+		opts:         []Option{WithSourceAttributes("css-module", "styleName")},
+		expectedHTML: `<div class="foo RAN_1 RAN_2"></div>`,
+
+		payload: `<div css-module="test-1" styleName="test-2" class="foo"></div>`,
+	},
+	{
+		name:         "TargetAttribute",
+		opts:         []Option{WithSourceAttributes("styleName"), WithTargetAttribute("className")},
+		expectedHTML: `<div class="foo" className="bar RAN_1"></div>`,
+
+		payload: `<div styleName="test-1" class="foo" className="bar"></div>`,
+	},
+}
+
+func TestProcessHTMLWithCSSModules_Attributes(t *testing.T) {
+	classes := map[string]string{"test-1": "RAN_1", "test-2": "RAN_2"}
+	for i := range testCasesHTMLCSSModulesAttributes {
+		tc := testCasesHTMLCSSModulesAttributes[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			resultingHTML, err := ProcessHTMLWithCSSModules(strings.NewReader(tc.payload), classes, tc.opts...)
+			if err != nil {
+				t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+				return
+			}
+			if string(resultingHTML) != tc.expectedHTML {
+				t.Errorf("unexpected html value: expected %s got %s", tc.expectedHTML, resultingHTML)
+			}
+		})
+	}
+}
+
+func TestHTMLProcessor_processTag_ZeroAllocs(t *testing.T) {
+	p := newHTMLProcessor(newConfig(nil))
+	p.w = &bytes.Buffer{}
+	raw := []byte(`<a href="/home" class="link" data-id=1 disabled>`)
+	allocs := testing.AllocsPerRun(100, func() {
+		p.w.(*bytes.Buffer).Reset()
+		if err := p.processTag(raw, false); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("unexpected allocations: expected 0 got %v", allocs)
+	}
+}
+
+func TestProcessHTMLWithCSSModules_TemplateDelims(t *testing.T) {
+	payload := `<a title="[[printf "%q>" .X]]" css-module="test-1" [[if .Hidden]]hidden[[end]]>Link</a>`
+	expectedHTML := `<a title="[[printf "%q>" .X]]" class="RAN_1" [[if .Hidden]]hidden[[end]]>Link</a>`
+	resultingHTML, err := ProcessHTMLWithCSSModules(strings.NewReader(payload), map[string]string{"test-1": "RAN_1"}, WithTemplateDelims("[[", "]]"))
+	if err != nil {
+		t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+		return
+	}
+	if string(resultingHTML) != expectedHTML {
+		t.Errorf("unexpected html value: expected %s got %s", expectedHTML, resultingHTML)
+	}
+}
+
+var testCasesHTMLCSSModulesDynamic = []struct {
+	name         string
+	payload      string
+	expectedHTML string
+	expectedErr  error
+}{
+	{
+		name:         "ControlActions",
+		expectedHTML: `<button class="RAN_1 {{if .Primary}}RAN_2{{else}}RAN_3{{end}}">Ok</button>`,
+
+		payload: `<button css-module="test-1 {{if .Primary}}test-2{{else}}test-3{{end}}">Ok</button>`,
+	},
+	{
+		name:         "ValueActions",
+		expectedHTML: `<button class="RAN_1 {{cssmodule (.Variant)}} {{cssmodule (printf "test-%v" (.Size)) -}}">Ok</button>`,
+
+		payload: `<button css-module="test-1 {{.Variant}} test-{{.Size -}}">Ok</button>`,
+	},
+	{
+		name:         "CommentsAndVariables",
+		expectedHTML: `<div class="foo {{/* size */}}{{$s := .Size}}{{cssmodule (printf "%%v-%v" ($s | print))}}"></div>`,
+
+		payload: `<div class="foo" css-module="{{/* size */}}{{$s := .Size}}%v-{{$s | print}}"></div>`,
+	},
+	{
+		name:         "StaticClasses",
+		expectedHTML: `<div class="RAN_1 RAN_2"></div>`,
+
+		payload: `<div css-module="test-1 test-2"></div>`,
+	},
+	{
+		name:        "UnknownStaticClass",
+		expectedErr: ErrClassNotFound,
+
+		payload: `<div css-module="{{if .A}}test-4{{end}}"></div>`,
+	},
+}
+
+func TestProcessHTMLWithCSSModules_DynamicClasses(t *testing.T) {
+	classes := map[string]string{"test-1": "RAN_1", "test-2": "RAN_2", "test-3": "RAN_3"}
+	for i := range testCasesHTMLCSSModulesDynamic {
+		tc := testCasesHTMLCSSModulesDynamic[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			resultingHTML, err := ProcessHTMLWithCSSModules(strings.NewReader(tc.payload), classes, WithDynamicClasses())
+			if !errors.Is(err, tc.expectedErr) {
+				t.Errorf("unexpected error value: expected %v got %v", tc.expectedErr, err)
+				return
+			}
+			if string(resultingHTML) != tc.expectedHTML {
+				t.Errorf("unexpected html value: expected %s got %s", tc.expectedHTML, resultingHTML)
+			}
+		})
+	}
+}
+
+var testCasesHTMLCSSModulesModules = []struct {
+	name         string
+	payload      string
+	expectedHTML string
+	expectedErr  error
+}{
+	{
+		name:         "Aliases",
+		expectedHTML: `<div class="CARD_1 BTN_1 RAN_1"></div>`,
+
+		payload: `<div css-module="card:root button:primary test-1"></div>`,
+	},
+	{
+		name:         "Scope",
+		expectedHTML: `<div class="CARD_1"><div><p class="CARD_2"></p></div><br><button class="BTN_1"></button></div><p class="RAN_1"></p>`,
+
+		payload: `<div css-module-scope="card" css-module="root"><div><p css-module="title"></p></div><br css-module-scope="button"><button css-module-scope="button" css-module="primary"></button></div><p css-module="test-1"></p>`,
+	},
+	{
+		name:         "NestedScopes",
+		expectedHTML: `<div><div><p class="BTN_1 CARD_2"></p></div><p class="CARD_2"></p></div><p class="RAN_1"></p>`,
+
+		payload: `<div css-module-scope="card"><div css-module-scope="button"><p css-module="primary card:title"></p></div><p css-module="title"></p></div><p css-module="test-1"></p>`,
+	},
+	{
+		name:         "DynamicScope",
+		expectedHTML: `<div class="CARD_1 {{cssmodulein "card" (.Variant)}}"></div>`,
+
+		payload: `<div css-module-scope="card" css-module="root {{.Variant}}"></div>`,
+	},
+	{
+		name:        "UnknownAlias",
+		expectedErr: ErrModuleNotFound,
+
+		payload: `<div css-module="header:root"></div>`,
+	},
+	{
+		name:        "UnknownScope",
+		expectedErr: ErrModuleNotFound,
+
+		payload: `<div css-module-scope="header"></div>`,
+	},
+	{
+		name:        "ClassNotInScope",
+		expectedErr: ErrClassNotFound,
+
+		payload: `<div css-module-scope="card" css-module="test-1"></div>`,
+	},
+}
+
+func TestProcessHTMLWithCSSModules_Modules(t *testing.T) {
+	opts := []Option{
+		WithModule("card", map[string]string{"root": "CARD_1", "title": "CARD_2"}),
+		WithModule("button", map[string]string{"primary": "BTN_1"}),
+		WithDynamicClasses(),
+	}
+	classes := map[string]string{"test-1": "RAN_1"}
+	for i := range testCasesHTMLCSSModulesModules {
+		tc := testCasesHTMLCSSModulesModules[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			resultingHTML, err := ProcessHTMLWithCSSModules(strings.NewReader(tc.payload), classes, opts...)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Errorf("unexpected error value: expected %v got %v", tc.expectedErr, err)
+				return
+			}
+			if string(resultingHTML) != tc.expectedHTML {
+				t.Errorf("unexpected html value: expected %s got %s", tc.expectedHTML, resultingHTML)
+			}
+		})
+	}
+}
+
+var testCasesHTMLCSSModulesXML = []struct {
+	name         string
+	opts         []Option
+	payload      string
+	expectedHTML string
+}{
+	{
+		name:         "SVG",
+		expectedHTML: `<svg viewBox="0 0 24 24" xmlns:xlink="http://www.w3.org/1999/xlink"><g class="RAN_1"><path class="fill RAN_2"/><use xlink:href="#a"/></g><title>Logo <tspan class="RAN_1">x</tspan></title></svg>`,
+
+		payload: `<svg viewBox="0 0 24 24" xmlns:xlink="http://www.w3.org/1999/xlink"><g css-module="test-1"><path class="fill" css-module="test-2"/><use xlink:href="#a"/></g><title>Logo <tspan css-module="test-1">x</tspan></title></svg>`,
+	},
+	{
+		name:         "HTMLAfterSVG",
+		expectedHTML: `<svg><title>A</title></svg><title><p css-module="test-1"></title>`,
+
+		payload: `<svg><title>A</title></svg><title><p css-module="test-1"></title>`,
+	},
+	{
+		name:         "XHTML",
+		opts:         []Option{WithXML()},
+		expectedHTML: `<?xml version="1.0"?><html xmlns="http://www.w3.org/1999/xhtml"><br class="RAN_1"/><script><![CDATA[ a > b; <p css-module="test-3"> ]]></script><p Class="a" class="RAN_2" CSS-MODULE="test-3"></p></html>`,
+
+		payload: `<?xml version="1.0"?><html xmlns="http://www.w3.org/1999/xhtml"><br css-module="test-1"/><script><![CDATA[ a > b; <p css-module="test-3"> ]]></script><p Class="a" css-module="test-2" CSS-MODULE="test-3"></p></html>`,
+	},
+}
+
+func TestProcessHTMLWithCSSModules_XML(t *testing.T) {
+	classes := map[string]string{"test-1": "RAN_1", "test-2": "RAN_2"}
+	for i := range testCasesHTMLCSSModulesXML {
+		tc := testCasesHTMLCSSModulesXML[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			resultingHTML, err := ProcessHTMLWithCSSModules(strings.NewReader(tc.payload), classes, tc.opts...)
+			if err != nil {
+				t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+				return
+			}
+			if string(resultingHTML) != tc.expectedHTML {
+				t.Errorf("unexpected html value: expected\n%s\ngot\n%s", tc.expectedHTML, resultingHTML)
+			}
+		})
+	}
+}
@@ -2,7 +2,12 @@ package cssmodules
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -241,3 +246,173 @@ func TestCSSModulesParser_ParseTo(t *testing.T) {
 		})
 	}
 }
+
+var testCasesCSSModulesMode = []struct {
+	name                  string
+	payload               string
+	mode                  Mode
+	expectedCSSModules    string
+	expectedScopedClasses []string
+}{
+	{
+		name:                  "ModeLocal_LocalFunction",
+		mode:                  ModeLocal,
+		expectedCSSModules:    `.${a} .${b}{color:red}`,
+		expectedScopedClasses: []string{"a", "b"},
+
+		payload: `:local(.a) .b{color:red}`,
+	},
+	{
+		name:                  "ModeLocal_GlobalFunction",
+		mode:                  ModeLocal,
+		expectedCSSModules:    `.a .${b}{color:red}`,
+		expectedScopedClasses: []string{"b"},
+
+		payload: `:global(.a) .b{color:red}`,
+	},
+	{
+		name:                  "ModeGlobal_NothingScoped",
+		mode:                  ModeGlobal,
+		expectedCSSModules:    `.a .b{color:red} @media screen{.c{color:blue}}`,
+		expectedScopedClasses: nil,
+
+		payload: `.a .b{color:red} @media screen{.c{color:blue}}`,
+	},
+	{
+		name:                  "ModeGlobal_LocalFunction",
+		mode:                  ModeGlobal,
+		expectedCSSModules:    `.a .${b}:hover, .c{color:red}`,
+		expectedScopedClasses: []string{"b"},
+
+		payload: `.a :local(.b):hover, .c{color:red}`,
+	},
+	{
+		name:                  "ModeGlobal_LocalKeywordLastsUntilEndOfSelector",
+		mode:                  ModeGlobal,
+		expectedCSSModules:    `.a  .${b} .${c}, .d{color:red} .e{color:blue}`,
+		expectedScopedClasses: []string{"b", "c"},
+
+		payload: `.a :local .b .c, .d{color:red} .e{color:blue}`,
+	},
+}
+
+func TestProcessCSSModules_Mode(t *testing.T) {
+	for i := range testCasesCSSModulesMode {
+		tc := testCasesCSSModulesMode[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			css, scopedClasses, err := ProcessCSSModules(strings.NewReader(tc.payload), WithMode(tc.mode))
+			if err != nil {
+				t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+				return
+			}
+			if len(scopedClasses) != len(tc.expectedScopedClasses) {
+				t.Errorf("unexpected scopedClasses length: expected %d got %q map", len(tc.expectedScopedClasses), scopedClasses)
+				return
+			}
+			for _, esc := range tc.expectedScopedClasses {
+				if _, ok := scopedClasses[esc]; !ok {
+					t.Errorf("unexpected scopedClasses value absence: expected to have %q inside of it, got %q map", esc, scopedClasses)
+					return
+				}
+			}
+			expected := os.Expand(tc.expectedCSSModules, func(s string) string { return scopedClasses[s] })
+			if string(css) != expected {
+				t.Errorf("unexpected css value: expected\n%q\ngot\n%q", expected, css)
+			}
+		})
+	}
+}
+
+func TestProcessCSSModules_Concurrent(t *testing.T) {
+	payload := &strings.Builder{}
+	expected := &strings.Builder{}
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(payload, ".class-%d, .class-%d:hover{color:red}\n", i, i)
+		fmt.Fprintf(expected, ".${class-%d}, .${class-%d}:hover{color:red}\n", i, i)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				css, scopedClasses, err := ProcessCSSModules(strings.NewReader(payload.String()))
+				if err != nil {
+					t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+					return
+				}
+				if len(scopedClasses) != 50 {
+					t.Errorf("unexpected scopedClasses length: expected 50 got %d", len(scopedClasses))
+					return
+				}
+				exp := os.Expand(expected.String(), func(s string) string { return scopedClasses[s] })
+				if string(css) != exp {
+					t.Errorf("unexpected css value: expected\n%q\ngot\n%q", exp, css)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestProcessCSSModulesContext(t *testing.T) {
+	payload := ".test-1{color:red}\n.test-2{color:blue}"
+
+	// Cancelled before the `.test-2` delimiter, the 9th token
+	_, _, err := ProcessCSSModulesContext(newCancelAfterContext(8), strings.NewReader(payload))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error value: expected %q got %v", context.Canceled, err)
+		return
+	}
+	var posErr *PositionError
+	if !errors.As(err, &posErr) {
+		t.Errorf("unexpected error type: expected *PositionError got %T", err)
+		return
+	}
+	if expected := (Position{Offset: 19, Line: 2, Column: 1}); posErr.Pos != expected {
+		t.Errorf("unexpected position value: expected %+v got %+v", expected, posErr.Pos)
+	}
+
+	if _, err := NewCSSModulesParser(strings.NewReader(payload)).ParseToContext(newCancelAfterContext(0), &bytes.Buffer{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error value: expected %q got %v", context.Canceled, err)
+	}
+}
+
+func TestCSSModulesParser_Reset(t *testing.T) {
+	p := NewCSSModulesParser(strings.NewReader(`.test-1{color:red}`))
+	buf := &bytes.Buffer{}
+	if _, err := p.ParseTo(buf); err != nil {
+		t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+		return
+	}
+	if _, err := p.ParseTo(buf); err != ErrAlreadyWritten {
+		t.Errorf("unexpected error value: expected %q got %v", ErrAlreadyWritten, err)
+		return
+	}
+	// The stylesheets are read into the same buffer
+	input := p.input.Bytes()[:1]
+	for _, payload := range []string{`.test-2{color:red}`, `.test-3{color:red}`} {
+		buf.Reset()
+		p.Reset(strings.NewReader(payload))
+		scopedClasses, err := p.ParseTo(buf)
+		if err != nil {
+			t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+			return
+		}
+		if &p.input.Bytes()[0] != &input[0] {
+			t.Errorf("unexpected input buffer: expected the buffer to be reused")
+			return
+		}
+		if len(scopedClasses) != 1 {
+			t.Errorf("unexpected scopedClasses length: expected 1 got %q map", scopedClasses)
+			return
+		}
+		expected := os.Expand(strings.NewReplacer(".", ".${", "{", "}{").Replace(payload), func(s string) string { return scopedClasses[s] })
+		if buf.String() != expected {
+			t.Errorf("unexpected css value: expected\n%q\ngot\n%q", expected, buf.String())
+			return
+		}
+	}
+}
@@ -5,7 +5,14 @@ import "errors"
 // Errors HTML
 
 var (
-	ErrClassNotFound = errors.New("css modules class not found")
+	ErrClassNotFound  = errors.New("css modules class not found")
+	ErrModuleNotFound = errors.New("css modules module not found")
+)
+
+// Errors CSS
+
+var (
+	ErrLimitExceeded = errors.New("css modules safe mode limit exceeded")
 )
 
 // Errors common
@@ -13,3 +20,18 @@ var (
 var (
 	ErrAlreadyWritten = errors.New("the buffer of this struct has already been written")
 )
+
+// PositionError is returned when the processing is stopped at Pos, for example
+// because the context was cancelled.
+type PositionError struct {
+	Pos Position
+	Err error
+}
+
+func (e *PositionError) Error() string {
+	return e.Err.Error() + " (at " + e.Pos.String() + ")"
+}
+
+func (e *PositionError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,155 @@
+package cssmodules
+
+import "bytes"
+
+// delims are the delimiters of the template actions, actions aren't recognised
+// if left is empty.
+type delims struct {
+	left, right []byte
+}
+
+// htmlAttr is the span of an attribute in the raw bytes of a tag.
+type htmlAttr struct {
+	// start and end of the whole attribute
+	start, end int
+
+	keyStart, keyEnd int
+
+	// valStart and valEnd are the span of the value without its quotes, both
+	// are -1 if the attribute doesn't have a value
+	valStart, valEnd int
+
+	// quote is the quote of the value, 0 if it's unquoted
+	quote byte
+}
+
+func (a *htmlAttr) key(raw []byte) []byte {
+	return raw[a.keyStart:a.keyEnd]
+}
+
+func (a *htmlAttr) val(raw []byte) []byte {
+	if a.valStart == -1 {
+		return nil
+	}
+	return raw[a.valStart:a.valEnd]
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+// skipAction returns the index after the template action starting at i, or i if
+// there isn't an action starting at i.
+func skipAction(raw []byte, i int, d *delims) int {
+	if len(d.left) == 0 || !bytes.HasPrefix(raw[i:], d.left) {
+		return i
+	}
+	end := bytes.Index(raw[i+len(d.left):], d.right)
+	if end == -1 {
+		return len(raw)
+	}
+	return i + len(d.left) + end + len(d.right)
+}
+
+// hasUnclosedAction reports if b has a template action without its right
+// delimiter.
+func hasUnclosedAction(b []byte, d *delims) bool {
+	if len(d.left) == 0 {
+		return false
+	}
+	for {
+		i := bytes.Index(b, d.left)
+		if i == -1 {
+			return false
+		}
+		b = b[i+len(d.left):]
+		j := bytes.Index(b, d.right)
+		if j == -1 {
+			return true
+		}
+		b = b[j+len(d.right):]
+	}
+}
+
+// scanTag scans the raw bytes of a start tag following the rules of the HTML
+// tokenizer, except that template actions are skipped as a whole wherever they
+// are. It returns the end of the tag name and appends the attributes of the tag
+// to attrs.
+func scanTag(raw []byte, attrs []htmlAttr, d *delims) (int, []htmlAttr) {
+	i := 1
+	for i < len(raw) && !isHTMLSpace(raw[i]) && raw[i] != '/' && raw[i] != '>' {
+		if j := skipAction(raw, i, d); j != i {
+			i = j
+			continue
+		}
+		i++
+	}
+	nameEnd := i
+	for i < len(raw) {
+		for i < len(raw) && (isHTMLSpace(raw[i]) || raw[i] == '/') {
+			i++
+		}
+		if i >= len(raw) || raw[i] == '>' {
+			break
+		}
+
+		// The first character of the key can be '='. Actions in the place of
+		// attributes are scanned as keys
+		a := htmlAttr{start: i, keyStart: i, valStart: -1, valEnd: -1}
+		if j := skipAction(raw, i, d); j != i {
+			i = j
+		} else {
+			i++
+		}
+		for i < len(raw) && !isHTMLSpace(raw[i]) && raw[i] != '/' && raw[i] != '>' && raw[i] != '=' {
+			if j := skipAction(raw, i, d); j != i {
+				i = j
+				continue
+			}
+			i++
+		}
+		a.keyEnd = i
+		a.end = i
+
+		j := i
+		for j < len(raw) && isHTMLSpace(raw[j]) {
+			j++
+		}
+		if j < len(raw) && raw[j] == '=' {
+			j++
+			for j < len(raw) && isHTMLSpace(raw[j]) {
+				j++
+			}
+			if j < len(raw) && (raw[j] == '"' || raw[j] == '\'') {
+				a.quote = raw[j]
+				j++
+				a.valStart = j
+				for j < len(raw) && raw[j] != a.quote {
+					if k := skipAction(raw, j, d); k != j {
+						j = k
+						continue
+					}
+					j++
+				}
+				a.valEnd = j
+				if j < len(raw) {
+					j++
+				}
+			} else {
+				a.valStart = j
+				for j < len(raw) && !isHTMLSpace(raw[j]) && raw[j] != '>' {
+					if k := skipAction(raw, j, d); k != j {
+						j = k
+						continue
+					}
+					j++
+				}
+				a.valEnd = j
+			}
+			i = j
+			a.end = j
+		}
+		attrs = append(attrs, a)
+	}
+	return nameEnd, attrs
+}
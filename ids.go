@@ -0,0 +1,129 @@
+package cssmodules
+
+import (
+	"bytes"
+	"strings"
+)
+
+// WithIDScoping makes the CSS processor scope the ID selectors like the
+// classes, and the IDs referenced as `url(#id)`, keeping them under the key
+// `#id`. The HTML processor rewrites the `id` attributes, the `href="#id"` and
+// `xlink:href="#id"` references and the `url(#id)` references in the other
+// attributes of the IDs found in the scoped classes, leaving the others as they
+// are. Useful for the gradients, masks and symbols of inline SVG icons.
+func WithIDScoping() Option {
+	return func(c *config) {
+		c.idScoping = true
+	}
+}
+
+var (
+	idAttr        = []byte("id")
+	hrefAttr      = []byte("href")
+	urlPrefix     = []byte("url(")
+	namespaceHref = []byte(":href")
+)
+
+// scopeReferences sets p.refs to the values replacing the values of the
+// attributes referencing scoped IDs, and of the `style` attribute if styles is
+// set, "" for the attributes left as they are. It reports if there is any.
+func (p *htmlProcessor) scopeReferences(raw []byte, attrs []htmlAttr, styles bool) bool {
+	p.refs = p.refs[:0]
+	found := false
+	for i := range attrs {
+		a := &attrs[i]
+		key, val := a.key(raw), a.val(raw)
+		ref := ""
+		switch {
+		case a.valStart == -1 || p.cfg.isSourceAttr(key) || p.cfg.isTargetAttr(key):
+		case styles && p.cfg.equalName(key, styleAttr):
+			ref = p.rewriteStyleAttr(val)
+			if p.cfg.idScoping {
+				if ref != "" {
+					val = []byte(ref)
+				}
+				if scoped := p.scopeURLRefs(val); scoped != "" {
+					ref = scoped
+				}
+			}
+		case !p.cfg.idScoping:
+		case p.cfg.equalName(key, idAttr):
+			if scoped, ok := p.lookupID(bytes.TrimSpace(val)); ok {
+				ref = scoped
+			}
+		case p.cfg.equalName(key, hrefAttr) || (len(key) > len(namespaceHref) && p.cfg.equalName(key[len(key)-len(namespaceHref):], namespaceHref)):
+			if len(val) > 1 && val[0] == '#' {
+				if scoped, ok := p.lookupID(val[1:]); ok {
+					ref = "#" + scoped
+				}
+			}
+		case bytes.Contains(val, urlPrefix) && bytes.IndexByte(val, '#') != -1:
+			ref = p.scopeURLRefs(val)
+		}
+		p.refs = append(p.refs, ref)
+		found = found || ref != ""
+	}
+	return found
+}
+
+// lookupID returns the scoped ID of id with the resolver of the tag.
+func (p *htmlProcessor) lookupID(id []byte) (string, bool) {
+	if len(id) == 0 {
+		return "", false
+	}
+	p.idBuf = append(append(p.idBuf[:0], '#'), id...)
+	return p.lookupKey(p.idBuf)
+}
+
+// lookupKey returns the scoped name kept under key with the resolver of the
+// tag.
+func (p *htmlProcessor) lookupKey(key []byte) (string, bool) {
+	if m, ok := p.resolver.(ClassMap); ok {
+		scoped, exists := m[string(key)]
+		return scoped, exists
+	}
+	scoped, err := p.resolver.Resolve(string(key))
+	if err != nil || len(scoped) == 0 {
+		return "", false
+	}
+	return scoped[0], true
+}
+
+// scopeURLRefs returns val with its `url(#id)` references scoped, or "" if
+// none of them is.
+func (p *htmlProcessor) scopeURLRefs(val []byte) string {
+	sb := strings.Builder{}
+	written := 0
+	for i := 0; ; {
+		j := bytes.Index(val[i:], urlPrefix)
+		if j == -1 {
+			break
+		}
+		start := i + j + len(urlPrefix)
+		for start < len(val) && (isHTMLSpace(val[start]) || val[start] == '"' || val[start] == '\'') {
+			start++
+		}
+		i = start
+		if start == len(val) || val[start] != '#' {
+			continue
+		}
+		start++
+		end := start
+		for end < len(val) && val[end] != ')' && val[end] != '"' && val[end] != '\'' && !isHTMLSpace(val[end]) {
+			end++
+		}
+		i = end
+		scoped, ok := p.lookupID(val[start:end])
+		if !ok {
+			continue
+		}
+		sb.Write(val[written:start])
+		sb.WriteString(scoped)
+		written = end
+	}
+	if written == 0 {
+		return ""
+	}
+	sb.Write(val[written:])
+	return sb.String()
+}
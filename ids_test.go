@@ -0,0 +1,73 @@
+package cssmodules
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestProcessCSSModules_IDScoping(t *testing.T) {
+	payload := `#logo{fill:#fff} .icon #mark, :global(#app) a{stroke:url(#grad);mask:url( "#mask" )} ` +
+		`@media screen{#logo{color:#000}} @font-face{src:url(font.woff)} .a{background:url(#)}`
+	css, scopedClasses, err := ProcessCSSModules(strings.NewReader(payload), WithIDScoping())
+	if err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	expected := os.Expand(`#${#logo}{fill:#fff} .${icon} #${#mark}, #app a{stroke:url(#${#grad});mask:url( "#${#mask}" )} `+
+		`@media screen{#${#logo}{color:#000}} @font-face{src:url(font.woff)} .${a}{background:url(#)}`, func(s string) string { return scopedClasses[s] })
+	if len(scopedClasses) != 6 || string(css) != expected {
+		t.Errorf("unexpected css value: expected\n%q\ngot\n%q with %q map", expected, css, scopedClasses)
+	}
+
+	// Without the option the IDs are global
+	css, _, err = ProcessCSSModules(strings.NewReader(`#logo{fill:url(#grad)}`))
+	if err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	if string(css) != `#logo{fill:url(#grad)}` {
+		t.Errorf("unexpected css value: expected %q got %q", `#logo{fill:url(#grad)}`, css)
+	}
+}
+
+func TestProcessCSSModules_IDScopingAtRules(t *testing.T) {
+	// The hash tokens of the at-rule preludes aren't ID selectors
+	payload := `@supports (color: #fff) and (not selector(#a)){#logo{color:#000}} @media screen{@supports (color:#000){#b{color:red}}}`
+	css, scopedClasses, err := ProcessCSSModules(strings.NewReader(payload), WithIDScoping())
+	if err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	expected := os.Expand(`@supports (color: #fff) and (not selector(#a)){#${#logo}{color:#000}} @media screen{@supports (color:#000){#${#b}{color:red}}}`, func(s string) string { return scopedClasses[s] })
+	if len(scopedClasses) != 2 || string(css) != expected {
+		t.Errorf("unexpected css value: expected\n%q\ngot\n%q with %q map", expected, css, scopedClasses)
+	}
+}
+
+func TestProcessCSSModules_IDScopingModeGlobal(t *testing.T) {
+	// Only the IDs and url(#id) references of the local selectors are scoped
+	payload := `#logo{fill:url(#grad)} :local .icon #mark{stroke:red}`
+	css, scopedClasses, err := ProcessCSSModules(strings.NewReader(payload), WithIDScoping(), WithMode(ModeGlobal))
+	if err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	expected := os.Expand(`#logo{fill:url(#grad)}  .${icon} #${#mark}{stroke:red}`, func(s string) string { return scopedClasses[s] })
+	if len(scopedClasses) != 2 || string(css) != expected {
+		t.Errorf("unexpected css value: expected\n%q\ngot\n%q with %q map", expected, css, scopedClasses)
+	}
+}
+
+func TestProcessHTMLWithCSSModules_IDScoping(t *testing.T) {
+	classes := map[string]string{"icon": "RAN_1", "#grad": "ID_1", "#mark": "ID_2"}
+	payload := `<svg viewBox="0 0 24 24"><defs><linearGradient id="grad"/></defs>` +
+		`<path css-module="icon" fill="url(#grad)" style="stroke:url('#grad');mask:url(#other)" d="M0 0"/>` +
+		`<use xlink:href="#mark" href="#other"/></svg><a href="#grad" id="top">Top</a>`
+	expectedHTML := `<svg viewBox="0 0 24 24"><defs><linearGradient id="ID_1"/></defs>` +
+		`<path class="RAN_1" fill="url(#ID_1)" style="stroke:url('#ID_1');mask:url(#other)" d="M0 0"/>` +
+		`<use xlink:href="#ID_2" href="#other"/></svg><a href="#ID_1" id="top">Top</a>`
+	resultingHTML, err := ProcessHTMLWithCSSModules(strings.NewReader(payload), classes, WithIDScoping())
+	if err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	if string(resultingHTML) != expectedHTML {
+		t.Errorf("unexpected html value: expected\n%s\ngot\n%s", expectedHTML, resultingHTML)
+	}
+}
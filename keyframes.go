@@ -0,0 +1,45 @@
+package cssmodules
+
+// WithKeyframesScoping makes the CSS processor scope the names of the
+// `@keyframes` rules and the keyframes names used by the `animation` and
+// `animation-name` declarations, keeping them under the key `@name`. Only
+// ModeLocal scopes them.
+func WithKeyframesScoping() Option {
+	return func(c *config) {
+		c.keyframesScoping = true
+	}
+}
+
+// WithCustomPropertiesScoping makes the CSS processor scope the custom
+// properties, `--gap` becoming `--_gap_hash` both in its declarations and in
+// `var(--gap)`, keeping them under the key `--gap`. Only ModeLocal scopes them.
+func WithCustomPropertiesScoping() Option {
+	return func(c *config) {
+		c.customPropertiesScoping = true
+	}
+}
+
+// scopeKeyframes writes the scoped name of a keyframes name. The scoped names
+// are kept under the key `@name`, without the key conventions.
+func (p *cssProcessor) scopeKeyframes(data []byte) {
+	p.keyBuf = append(append(p.keyBuf[:0], '@'), data...)
+	if scoped, ok := p.scopedClasses[string(p.keyBuf)]; ok {
+		p.w.WriteString(scoped)
+		return
+	}
+	scoped := p.scopedName(data)
+	p.w.WriteString(scoped)
+	p.scopedClasses[string(p.keyBuf)] = scoped
+}
+
+// scopeCustomProperty writes the scoped name of a custom property, keeping it
+// under the name of the property.
+func (p *cssProcessor) scopeCustomProperty(data []byte) {
+	if scoped, ok := p.scopedClasses[string(data)]; ok {
+		p.w.WriteString(scoped)
+		return
+	}
+	scoped := "--" + p.scopedName(data[2:])
+	p.w.WriteString(scoped)
+	p.scopedClasses[string(data)] = scoped
+}
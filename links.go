@@ -0,0 +1,235 @@
+package cssmodules
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tdewolff/parse/v2"
+	html_parser "golang.org/x/net/html"
+)
+
+// WithLinkModules makes the HTML processor process the CSS modules linked by
+// the document, the `<link rel="stylesheet">` elements with an `href` ending
+// in `.module.css`,
+// reading them from fsys. Their classes are available to the `css-module`
+// attributes of the document like the classes of a `<style module>` element,
+// a `module="card"` attribute naming the module. The `href` is rewritten to
+// the path of the CSS processed, recorded in m, and the `module` attribute is
+// removed:
+//
+//	<link rel="stylesheet" href="/components/card.module.css">
+//	<link rel="stylesheet" href="/assets/card.3f9a2c.css">
+//
+// Every module is processed once per manifest, so the documents sharing a
+// manifest share the scoped classes of their modules. A nil manifest is
+// replaced by a new one.
+func WithLinkModules(fsys fs.FS, m *Manifest) Option {
+	if m == nil {
+		m = &Manifest{}
+	}
+	return func(c *config) {
+		c.linkFS = fsys
+		c.manifest = m
+	}
+}
+
+// Manifest records the CSS modules processed for the `<link>` elements, see
+// WithLinkModules. It's safe for concurrent use.
+type Manifest struct {
+	// Prefix is the path the assets are served from, like `/assets/`
+	Prefix string
+
+	// mu guards assets, the modules processed, and loads, the modules being
+	// processed
+	mu     sync.Mutex
+	assets map[string]*Asset
+	loads  map[string]*assetLoad
+}
+
+// assetLoad processes a module once for the documents loading it at the same
+// time.
+type assetLoad struct {
+	once  sync.Once
+	asset *Asset
+	err   error
+}
+
+// Asset is a CSS module processed for a `<link>` element.
+type Asset struct {
+	// Source is the path of the module in the file system
+	Source string
+	// Path is the path of the CSS processed, the prefix of the manifest
+	// followed by the name of the module with a hash of its content,
+	// `card.3f9a2c.css` for `card.module.css`
+	Path string
+	CSS  []byte
+
+	// Classes must not be modified
+	Classes map[string]string
+}
+
+// Assets returns the assets of the manifest sorted by their source.
+func (m *Manifest) Assets() []Asset {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	assets := make([]Asset, 0, len(m.assets))
+	for _, a := range m.assets {
+		assets = append(assets, *a)
+	}
+	sort.Slice(assets, func(i, j int) bool {
+		return assets[i].Source < assets[j].Source
+	})
+	return assets
+}
+
+// load returns the asset of the module at name, processing it with cfg the
+// first time. The module is read and processed without holding the lock, once
+// for the documents loading it at the same time; it's loaded again after an
+// error.
+func (m *Manifest) load(ctx context.Context, cfg *config, name string) (*Asset, error) {
+	m.mu.Lock()
+	if a, ok := m.assets[name]; ok {
+		m.mu.Unlock()
+		return a, nil
+	}
+	l, ok := m.loads[name]
+	if !ok {
+		l = &assetLoad{}
+		if m.loads == nil {
+			m.loads = map[string]*assetLoad{}
+		}
+		m.loads[name] = l
+	}
+	m.mu.Unlock()
+
+	l.once.Do(func() {
+		l.asset, l.err = m.process(ctx, cfg, name)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if l.err == nil {
+			if m.assets == nil {
+				m.assets = map[string]*Asset{}
+			}
+			m.assets[name] = l.asset
+		}
+		delete(m.loads, name)
+	})
+	return l.asset, l.err
+}
+
+// process reads the module at name and processes it with cfg.
+func (m *Manifest) process(ctx context.Context, cfg *config, name string) (*Asset, error) {
+	src, err := fs.ReadFile(cfg.linkFS, name)
+	if err != nil {
+		return nil, err
+	}
+	out := &bytes.Buffer{}
+	classes, err := newCSSProcessor(cfg).process(ctx, parse.NewInputBytes(src), out)
+	if err != nil {
+		return nil, fmt.Errorf("cssmodules: %s: %w", name, err)
+	}
+	sum := sha256.Sum256(out.Bytes())
+	base := strings.TrimSuffix(path.Base(name), moduleExt)
+	return &Asset{
+		Source:  name,
+		Path:    m.Prefix + base + "." + hex.EncodeToString(sum[:3]) + ".css",
+		CSS:     out.Bytes(),
+		Classes: classes,
+	}, nil
+}
+
+const moduleExt = ".module.css"
+
+var (
+	linkTag        = []byte("link")
+	relAttr        = []byte("rel")
+	stylesheetLink = "stylesheet"
+)
+
+// linkModuleAttrs returns the indexes of the `href` and `module` attributes in
+// attrs if the tag is a `<link rel="stylesheet">` element linking a CSS
+// module, -1 otherwise. The other links, like `rel="preload"`, are left as
+// they are.
+func linkModuleAttrs(raw []byte, nameEnd int, attrs []htmlAttr) (href, module int) {
+	href, module = -1, -1
+	if !bytes.EqualFold(raw[1:nameEnd], linkTag) {
+		return -1, -1
+	}
+	stylesheet := false
+	for i := range attrs {
+		switch key := attrs[i].key(raw); {
+		case href == -1 && bytes.EqualFold(key, hrefAttr):
+			href = i
+		case module == -1 && bytes.EqualFold(key, moduleAttr):
+			module = i
+		case bytes.EqualFold(key, relAttr):
+			stylesheet = isStylesheetRel(attrs[i].val(raw))
+		}
+	}
+	if !stylesheet || href == -1 || !strings.HasSuffix(linkModulePath(attrs[href].val(raw)), moduleExt) {
+		return -1, -1
+	}
+	return href, module
+}
+
+// isStylesheetRel reports if the `rel` attribute of a `<link>` element has the
+// `stylesheet` keyword, like `rel="alternate stylesheet"`.
+func isStylesheetRel(rel []byte) bool {
+	for _, kw := range strings.Fields(html_parser.UnescapeString(string(rel))) {
+		if strings.EqualFold(kw, stylesheetLink) {
+			return true
+		}
+	}
+	return false
+}
+
+// linkModulePath returns the path in the file system of the `href` of a
+// `<link>` element, "" for the URLs of other hosts.
+func linkModulePath(href []byte) string {
+	s := html_parser.UnescapeString(string(bytes.TrimSpace(href)))
+	if strings.HasPrefix(s, "//") || strings.Contains(s, "://") {
+		return ""
+	}
+	if i := strings.IndexAny(s, "?#"); i != -1 {
+		s = s[:i]
+	}
+	return strings.TrimPrefix(path.Clean("/"+s), "/")
+}
+
+// processLinkModule writes a `<link>` tag of a CSS module with its `href`
+// replaced by the path of the asset and without its `module` attribute,
+// reporting if the tag is one.
+func (p *htmlProcessor) processLinkModule(raw []byte) bool {
+	nameEnd, attrs := scanTag(raw, p.attrs[:0], &p.cfg.delims)
+	p.attrs = attrs
+	href, module := linkModuleAttrs(raw, nameEnd, attrs)
+	if href == -1 || p.link >= len(p.links) {
+		return false
+	}
+	written, prev := 0, nameEnd
+	for i := range attrs {
+		a := &attrs[i]
+		switch i {
+		case href:
+			p.w.Write(raw[written:a.valStart])
+			p.w.WriteString(html_parser.EscapeString(p.links[p.link]))
+			written = a.valEnd
+		case module:
+			// The attribute is removed along with the whitespace before it
+			p.w.Write(raw[written:prev])
+			written = a.end
+		}
+		prev = a.end
+	}
+	p.w.Write(raw[written:])
+	p.link++
+	return true
+}
@@ -0,0 +1,119 @@
+package cssmodules
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+)
+
+func TestProcessHTMLWithCSSModules_LinkModules(t *testing.T) {
+	fsys := fstest.MapFS{
+		"components/card.module.css":   {Data: []byte(`.card{color:red} .title{margin:0}`)},
+		"components/button.module.css": {Data: []byte(`.btn{color:blue} .title{margin:1px}`)},
+	}
+	m := &Manifest{Prefix: "/assets/"}
+	payload := `<head><link rel="stylesheet" href="/components/card.module.css">` +
+		`<link rel="stylesheet" module="button" href="components/button.module.css?v=2"/>` +
+		`<link rel="stylesheet" href="https://cdn.example.com/x.module.css"><link rel="icon" href="/favicon.ico">` +
+		`<link rel="preload" href="/components/other.module.css" as="style"></head>` +
+		`<div css-module="card test-1"><h2 css-module="title">Hi</h2><a css-module="button:btn">Ok</a></div>`
+	resultingHTML, err := ProcessHTMLWithCSSModules(strings.NewReader(payload), map[string]string{"test-1": "RAN_1"}, WithLinkModules(fsys, m))
+	if err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+
+	assets := m.Assets()
+	if len(assets) != 2 || assets[0].Source != "components/button.module.css" || assets[1].Source != "components/card.module.css" {
+		t.Fatalf("unexpected assets value: %+v", assets)
+	}
+	button, card := assets[0], assets[1]
+	if !strings.HasPrefix(card.Path, "/assets/card.") || !strings.HasSuffix(card.Path, ".css") || len(card.Path) != len("/assets/card.3f9a2c.css") {
+		t.Errorf("unexpected path value: expected /assets/card.<hash>.css got %s", card.Path)
+	}
+	expectedHTML := os.Expand(`<head><link rel="stylesheet" href="${card.css}">`+
+		`<link rel="stylesheet" href="${button.css}"/>`+
+		`<link rel="stylesheet" href="https://cdn.example.com/x.module.css"><link rel="icon" href="/favicon.ico">`+
+		`<link rel="preload" href="/components/other.module.css" as="style"></head>`+
+		`<div class="${card} RAN_1"><h2 class="${title}">Hi</h2><a class="${btn}">Ok</a></div>`, func(s string) string {
+		switch s {
+		case "card.css":
+			return card.Path
+		case "button.css":
+			return button.Path
+		case "btn":
+			return button.Classes[s]
+		}
+		return card.Classes[s]
+	})
+	if string(resultingHTML) != expectedHTML {
+		t.Errorf("unexpected html value: expected\n%s\ngot\n%s", expectedHTML, resultingHTML)
+	}
+	expectedCSS := os.Expand(`.${card}{color:red} .${title}{margin:0}`, func(s string) string { return card.Classes[s] })
+	if string(card.CSS) != expectedCSS {
+		t.Errorf("unexpected css value: expected %q got %q", expectedCSS, card.CSS)
+	}
+
+	// The documents sharing the manifest share the modules
+	resultingHTML, err = ProcessHTMLWithCSSModules(strings.NewReader(`<link rel="stylesheet" href="components/card.module.css"><p css-module="card"></p>`), nil, WithLinkModules(fsys, m))
+	if err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	expectedHTML = `<link rel="stylesheet" href="` + card.Path + `"><p class="` + card.Classes["card"] + `"></p>`
+	if string(resultingHTML) != expectedHTML || len(m.Assets()) != 2 {
+		t.Errorf("unexpected html value: expected\n%s\ngot\n%s", expectedHTML, resultingHTML)
+	}
+}
+
+func TestProcessHTMLWithCSSModules_LinkModulesNotFound(t *testing.T) {
+	payload := `<link rel="stylesheet" href="missing.module.css">`
+	_, err := ProcessHTMLWithCSSModules(strings.NewReader(payload), nil, WithLinkModules(fstest.MapFS{}, nil))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("unexpected error value: expected %v got %v", fs.ErrNotExist, err)
+	}
+}
+
+// countFS counts the files opened.
+type countFS struct {
+	fs.FS
+	opened atomic.Int32
+}
+
+func (c *countFS) Open(name string) (fs.File, error) {
+	c.opened.Add(1)
+	return c.FS.Open(name)
+}
+
+func TestProcessHTMLWithCSSModules_LinkModulesConcurrent(t *testing.T) {
+	fsys := &countFS{FS: fstest.MapFS{"card.module.css": {Data: []byte(`.card{color:red}`)}}}
+	m := &Manifest{}
+	payload := `<link rel="stylesheet" href="card.module.css"><p css-module="card"></p>`
+	results := make([][]byte, 8)
+	wg := sync.WaitGroup{}
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resultingHTML, err := ProcessHTMLWithCSSModules(strings.NewReader(payload), nil, WithLinkModules(fsys, m))
+			if err != nil {
+				t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+			}
+			results[i] = resultingHTML
+		}(i)
+	}
+	wg.Wait()
+
+	// The module is processed once and shared by every document
+	if n := fsys.opened.Load(); n != 1 {
+		t.Errorf("unexpected opened value: expected 1 got %d", n)
+	}
+	for _, resultingHTML := range results[1:] {
+		if string(resultingHTML) != string(results[0]) {
+			t.Errorf("unexpected html value: expected\n%s\ngot\n%s", results[0], resultingHTML)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package cssmodules
+
+// LocalsConvention controls which keys are used for the key-value pairs of
+// classes and scoped classes, following the `localsConvention` option of
+// css-loader.
+type LocalsConvention int
+
+const (
+	// LocalsAsIs keys the classes by their literal name (`my-class`). This is
+	// the default.
+	LocalsAsIs LocalsConvention = iota
+
+	// LocalsCamelCase keys the classes by their literal name and by their
+	// camel cased name (`my-class` and `myClass`).
+	LocalsCamelCase
+
+	// LocalsCamelCaseOnly keys the classes only by their camel cased name
+	// (`myClass`).
+	LocalsCamelCaseOnly
+
+	// LocalsDashes keys the classes by their literal name and by their name
+	// with only the dashes camel cased (`my_class-name` and `my_className`).
+	LocalsDashes
+
+	// LocalsDashesOnly keys the classes only by their name with the dashes
+	// camel cased (`my_className`).
+	LocalsDashesOnly
+)
+
+// keys returns the keys that the class name must be stored under.
+func (lc LocalsConvention) keys(name string) (string, string) {
+	switch lc {
+	case LocalsCamelCase:
+		return name, camelCase(name)
+	case LocalsCamelCaseOnly:
+		return camelCase(name), ""
+	case LocalsDashes:
+		return name, dashesCamelCase(name)
+	case LocalsDashesOnly:
+		return dashesCamelCase(name), ""
+	}
+	return name, ""
+}
+
+// convert returns the spelling that the class name would be stored under if it
+// were only stored once.
+func (lc LocalsConvention) convert(name string) string {
+	switch lc {
+	case LocalsCamelCase, LocalsCamelCaseOnly:
+		return camelCase(name)
+	case LocalsDashes, LocalsDashesOnly:
+		return dashesCamelCase(name)
+	}
+	return name
+}
+
+// camelCase converts names like `my-class` or `my_class` to `myClass`.
+func camelCase(name string) string {
+	return toCamelCase(name, func(c byte) bool { return c == '-' || c == '_' }, true)
+}
+
+// dashesCamelCase converts names like `my-class` to `myClass`, leaving any
+// other character untouched.
+func dashesCamelCase(name string) string {
+	return toCamelCase(name, func(c byte) bool { return c == '-' }, false)
+}
+
+func toCamelCase(name string, isSeparator func(byte) bool, lowerFirst bool) string {
+	b := make([]byte, 0, len(name))
+	upperNext := false
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if isSeparator(c) {
+			// Leading separators are dropped instead of upper casing the first
+			// letter, like css-loader does
+			upperNext = len(b) != 0
+			continue
+		}
+		if upperNext && 'a' <= c && c <= 'z' {
+			c -= 'a' - 'A'
+		} else if len(b) == 0 && lowerFirst && 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		upperNext = false
+		b = append(b, c)
+	}
+	if len(b) == 0 {
+		return name
+	}
+	return string(b)
+}
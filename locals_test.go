@@ -0,0 +1,86 @@
+package cssmodules
+
+import (
+	"strings"
+	"testing"
+)
+
+var testCasesLocalsConvention = []struct {
+	name         string
+	convention   LocalsConvention
+	expectedKeys []string
+}{
+	{
+		name:         "LocalsAsIs",
+		convention:   LocalsAsIs,
+		expectedKeys: []string{"my-class", "my_other-class"},
+	},
+	{
+		name:         "LocalsCamelCase",
+		convention:   LocalsCamelCase,
+		expectedKeys: []string{"my-class", "myClass", "my_other-class", "myOtherClass"},
+	},
+	{
+		name:         "LocalsCamelCaseOnly",
+		convention:   LocalsCamelCaseOnly,
+		expectedKeys: []string{"myClass", "myOtherClass"},
+	},
+	{
+		name:         "LocalsDashes",
+		convention:   LocalsDashes,
+		expectedKeys: []string{"my-class", "myClass", "my_other-class", "my_otherClass"},
+	},
+	{
+		name:         "LocalsDashesOnly",
+		convention:   LocalsDashesOnly,
+		expectedKeys: []string{"myClass", "my_otherClass"},
+	},
+}
+
+func TestProcessCSSModules_LocalsConvention(t *testing.T) {
+	for i := range testCasesLocalsConvention {
+		tc := testCasesLocalsConvention[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, scopedClasses, err := ProcessCSSModules(strings.NewReader(`.my-class{color:red} .my_other-class{color:blue}`), WithLocalsConvention(tc.convention))
+			if err != nil {
+				t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+				return
+			}
+			if len(scopedClasses) != len(tc.expectedKeys) {
+				t.Errorf("unexpected scopedClasses length: expected %d got %q map", len(tc.expectedKeys), scopedClasses)
+				return
+			}
+			for _, k := range tc.expectedKeys {
+				if _, ok := scopedClasses[k]; !ok {
+					t.Errorf("unexpected scopedClasses value absence: expected to have %q inside of it, got %q map", k, scopedClasses)
+					return
+				}
+			}
+		})
+	}
+}
+
+func TestProcessHTMLWithCSSModules_LocalsConvention(t *testing.T) {
+	for i := range testCasesLocalsConvention {
+		tc := testCasesLocalsConvention[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, scopedClasses, err := ProcessCSSModules(strings.NewReader(`.my-class{color:red}`), WithLocalsConvention(tc.convention))
+			if err != nil {
+				t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+				return
+			}
+			class := scopedClasses[tc.convention.convert("my-class")]
+			expected := `<p class="` + class + ` ` + class + `"></p>`
+			html, err := ProcessHTMLWithCSSModules(strings.NewReader(`<p css-module="my-class `+tc.convention.convert("my-class")+`"></p>`), scopedClasses, WithLocalsConvention(tc.convention))
+			if err != nil {
+				t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+				return
+			}
+			if string(html) != expected {
+				t.Errorf("unexpected html value: expected %s got %s", expected, html)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package cssmodules
+
+import "bytes"
+
+// MergeOrder is the order of the classes of the target attribute and the
+// scoped classes merged into it.
+type MergeOrder int
+
+const (
+	// ModuleClassesLast writes the scoped classes after the classes of the
+	// target attribute. This is the default.
+	ModuleClassesLast MergeOrder = iota
+
+	// ModuleClassesFirst writes the scoped classes before the classes of the
+	// target attribute.
+	ModuleClassesFirst
+)
+
+// MergePolicy configures how the scoped classes of the source attributes are
+// merged into the target attribute. The zero value appends the scoped classes
+// to the trimmed value of the target attribute and removes the source
+// attributes.
+type MergePolicy struct {
+	Order MergeOrder
+
+	// Dedupe removes the repeated classes, keeping the first one
+	Dedupe bool
+
+	// SplitHTMLSpace splits the classes of the source attributes on any HTML
+	// whitespace, tabs and newlines included, instead of only on spaces
+	SplitHTMLSpace bool
+
+	// KeepSource keeps the source attributes in the output, useful for
+	// debugging which classes an element came from
+	KeepSource bool
+}
+
+// WithMergePolicy sets how the scoped classes are merged into the target
+// attribute, see MergePolicy.
+func WithMergePolicy(mp MergePolicy) Option {
+	return func(c *config) {
+		c.merge = mp
+	}
+}
+
+// rewritesTarget reports if the classes of the target attribute are rewritten
+// rather than written as they are before the scoped classes.
+func (mp *MergePolicy) rewritesTarget() bool {
+	return mp.Dedupe || mp.Order != ModuleClassesLast
+}
+
+// indexSeparator returns the index of the first separator of the classes of a
+// source attribute in val, or -1 if there isn't any.
+func (c *config) indexSeparator(val []byte) int {
+	if !c.merge.SplitHTMLSpace {
+		return bytes.IndexByte(val, ' ')
+	}
+	for i, b := range val {
+		if isHTMLSpace(b) {
+			return i
+		}
+	}
+	return -1
+}
+
+// targetClasses appends the classes of the value of a target attribute to dst.
+func targetClasses(dst []string, val []byte) []string {
+	start := -1
+	for i := 0; i <= len(val); i++ {
+		if i != len(val) && !isHTMLSpace(val[i]) {
+			if start == -1 {
+				start = i
+			}
+			continue
+		}
+		if start != -1 {
+			dst = append(dst, string(val[start:i]))
+			start = -1
+		}
+	}
+	return dst
+}
+
+// mergeClasses writes the classes of the target attribute val merged with
+// p.names following the merge policy.
+func (p *htmlProcessor) mergeClasses(w writer, val []byte) {
+	mp := &p.cfg.merge
+	cw := classWriter{w: w, dedupe: mp.Dedupe, seen: p.seen[:0]}
+	if mp.rewritesTarget() {
+		p.targets = targetClasses(p.targets[:0], val)
+		cw.writeMerged(mp.Order, p.targets, p.names)
+	} else {
+		w.Write(val)
+		cw.space = len(val) != 0
+		cw.writeMerged(mp.Order, nil, p.names)
+	}
+	p.seen = cw.seen[:0]
+}
+
+// classWriter writes the classes of an attribute separated by spaces,
+// skipping the repeated ones if dedupe is set.
+type classWriter struct {
+	w      writer
+	space  bool
+	dedupe bool
+	seen   []string
+}
+
+func (cw *classWriter) write(class string) {
+	if cw.dedupe {
+		for _, c := range cw.seen {
+			if c == class {
+				return
+			}
+		}
+		cw.seen = append(cw.seen, class)
+	}
+	if cw.space {
+		cw.w.WriteByte(' ')
+	}
+	cw.w.WriteString(class)
+	cw.space = true
+}
+
+// writeMerged writes the classes of the target attribute and the scoped
+// classes in the order of the merge policy.
+func (cw *classWriter) writeMerged(order MergeOrder, target, scoped []string) {
+	first, last := target, scoped
+	if order == ModuleClassesFirst {
+		first, last = scoped, target
+	}
+	for _, class := range first {
+		cw.write(class)
+	}
+	for _, class := range last {
+		cw.write(class)
+	}
+}
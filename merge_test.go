@@ -0,0 +1,87 @@
+package cssmodules
+
+import (
+	"strings"
+	"testing"
+
+	html_parser "golang.org/x/net/html"
+)
+
+func TestWithMergePolicy(t *testing.T) {
+	classes := map[string]string{"card": "RAN_1", "title": "RAN_2", "box": "box"}
+	testCases := []struct {
+		name     string
+		policy   MergePolicy
+		payload  string
+		expected string
+	}{
+		{
+			name:     "Default",
+			payload:  `<div class=" a  box " css-module="card box"></div>`,
+			expected: `<div class="a  box RAN_1 box"></div>`,
+		},
+		{
+			name:     "ModuleClassesFirst",
+			policy:   MergePolicy{Order: ModuleClassesFirst},
+			payload:  `<div class="a	b" css-module="card title"></div>`,
+			expected: `<div class="RAN_1 RAN_2 a b"></div>`,
+		},
+		{
+			name:     "Dedupe",
+			policy:   MergePolicy{Dedupe: true},
+			payload:  `<div class="box a box" css-module="card box card"></div><p css-module="title title"></p>`,
+			expected: `<div class="box a RAN_1"></div><p class="RAN_2"></p>`,
+		},
+		{
+			name:     "SplitHTMLSpace",
+			policy:   MergePolicy{SplitHTMLSpace: true},
+			payload:  "<div css-module=\"card\ttitle\n box\"></div>",
+			expected: `<div class="RAN_1 RAN_2 box"></div>`,
+		},
+		{
+			name:     "KeepSource",
+			policy:   MergePolicy{KeepSource: true},
+			payload:  `<div css-module="card" id="x"></div><p class="a" css-module="title"></p>`,
+			expected: `<div css-module="card" class="RAN_1" id="x"></div><p class="a RAN_2" css-module="title"></p>`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resultingHTML, err := ProcessHTMLWithCSSModules(strings.NewReader(tc.payload), classes, WithMergePolicy(tc.policy))
+			if err != nil {
+				t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+			}
+			if string(resultingHTML) != tc.expected {
+				t.Errorf("unexpected html value: expected\n%s\ngot\n%s", tc.expected, resultingHTML)
+			}
+
+			// The compiled documents and the trees are merged the same way
+			compiled, err := CompileHTML(strings.NewReader(tc.payload), WithMergePolicy(tc.policy))
+			if err != nil {
+				t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+			}
+			sb := strings.Builder{}
+			if err := compiled.Render(&sb, ClassMap(classes)); err != nil {
+				t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+			}
+			if sb.String() != tc.expected {
+				t.Errorf("unexpected compiled html value: expected\n%s\ngot\n%s", tc.expected, sb.String())
+			}
+
+			root, err := html_parser.Parse(strings.NewReader(tc.payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ApplyToNode(root, ClassMap(classes), WithMergePolicy(tc.policy)); err != nil {
+				t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+			}
+			sb.Reset()
+			if err := html_parser.Render(&sb, root); err != nil {
+				t.Fatal(err)
+			}
+			if expected := `<html><head></head><body>` + tc.expected + `</body></html>`; sb.String() != expected {
+				t.Errorf("unexpected node html value: expected\n%s\ngot\n%s", expected, sb.String())
+			}
+		})
+	}
+}
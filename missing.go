@@ -0,0 +1,126 @@
+package cssmodules
+
+import (
+	"strings"
+
+	html_parser "golang.org/x/net/html"
+)
+
+// MissingClassPolicy controls what the HTML processor and the template
+// functions do with the classes that can't be resolved.
+type MissingClassPolicy int
+
+const (
+	// MissingClassError stops the processing at the first missing class,
+	// returning ErrClassNotFound. This is the default.
+	MissingClassError MissingClassPolicy = iota
+
+	// MissingClassKeep writes the missing classes as they are.
+	MissingClassKeep
+
+	// MissingClassDrop leaves the missing classes out.
+	MissingClassDrop
+
+	// MissingClassPlaceholder writes the placeholder class in place of the
+	// missing classes, see WithPlaceholderClass.
+	MissingClassPlaceholder
+)
+
+// DefaultPlaceholderClass is the class written in place of the missing classes
+// with MissingClassPlaceholder.
+const DefaultPlaceholderClass = "css-module-missing"
+
+// WithMissingClassPolicy sets what is done with the classes that can't be
+// resolved. With a policy other than MissingClassError the HTML processor
+// writes the whole document and returns a *MissingClassesError listing every
+// missing class once it's done.
+func WithMissingClassPolicy(policy MissingClassPolicy) Option {
+	return func(c *config) {
+		c.missingPolicy = policy
+	}
+}
+
+// WithPlaceholderClass sets the class written in place of the missing classes
+// with MissingClassPlaceholder, DefaultPlaceholderClass by default.
+func WithPlaceholderClass(class string) Option {
+	return func(c *config) {
+		c.placeholderClass = class
+	}
+}
+
+// MissingClass is a class that couldn't be resolved.
+type MissingClass struct {
+	// Pos is the position of the class in the document
+	Pos  Position
+	Name string
+	// Err is ErrClassNotFound or ErrModuleNotFound, or the error returned by
+	// the ClassResolver
+	Err error
+}
+
+// MissingClassesError is returned after processing a document with missing
+// classes when the MissingClassPolicy isn't MissingClassError. The output is
+// complete anyway.
+type MissingClassesError struct {
+	Missing []MissingClass
+}
+
+func (e *MissingClassesError) Error() string {
+	sb := strings.Builder{}
+	sb.WriteString(ErrClassNotFound.Error())
+	sb.WriteString(": ")
+	for i, m := range e.Missing {
+		if i != 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(m.Name)
+		sb.WriteString(" (at ")
+		sb.WriteString(m.Pos.String())
+		sb.WriteByte(')')
+	}
+	return sb.String()
+}
+
+func (e *MissingClassesError) Unwrap() error {
+	return ErrClassNotFound
+}
+
+// missingClass records the class that couldn't be resolved and returns the
+// class written in its place, escaped, or "" if nothing is written.
+func (p *htmlProcessor) missingClass(name []byte, pos Position, err error) (string, error) {
+	class, err := p.recordMissing(name, pos, err)
+	return html_parser.EscapeString(class), err
+}
+
+// recordMissing is like missingClass but returns the class unescaped.
+func (p *htmlProcessor) recordMissing(name []byte, pos Position, err error) (string, error) {
+	class, policyErr := p.cfg.missingClass(name, err)
+	if policyErr != nil {
+		return "", policyErr
+	}
+	p.missing = append(p.missing, MissingClass{Pos: pos, Name: string(name), Err: err})
+	return class, nil
+}
+
+// missingClass returns the class written in place of a missing class, "" if
+// nothing is, or err with the MissingClassError policy.
+func (c *config) missingClass(name []byte, err error) (string, error) {
+	switch c.missingPolicy {
+	case MissingClassError:
+		return "", err
+	case MissingClassKeep:
+		return string(name), nil
+	case MissingClassPlaceholder:
+		return c.placeholderClass, nil
+	}
+	return "", nil
+}
+
+// missingErr returns the error reporting the missing classes of the document,
+// if any.
+func (p *htmlProcessor) missingErr() error {
+	if len(p.missing) == 0 {
+		return nil
+	}
+	return &MissingClassesError{Missing: append([]MissingClass(nil), p.missing...)}
+}
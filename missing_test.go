@@ -0,0 +1,100 @@
+package cssmodules
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testCasesMissingClassPolicy = []struct {
+	name         string
+	opts         []Option
+	expectedHTML string
+}{
+	{
+		name:         "Keep",
+		opts:         []Option{WithMissingClassPolicy(MissingClassKeep)},
+		expectedHTML: "<div class=\"RAN_1 test-2\">\n  <p class=\"test-3 test-4\"></p>\n</div>",
+	},
+	{
+		name:         "Drop",
+		opts:         []Option{WithMissingClassPolicy(MissingClassDrop)},
+		expectedHTML: "<div class=\"RAN_1\">\n  <p class=\"\"></p>\n</div>",
+	},
+	{
+		name:         "Placeholder",
+		opts:         []Option{WithMissingClassPolicy(MissingClassPlaceholder)},
+		expectedHTML: "<div class=\"RAN_1 css-module-missing\">\n  <p class=\"css-module-missing\"></p>\n</div>",
+	},
+	{
+		name:         "CustomPlaceholder",
+		opts:         []Option{WithMissingClassPolicy(MissingClassPlaceholder), WithPlaceholderClass("missing")},
+		expectedHTML: "<div class=\"RAN_1 missing\">\n  <p class=\"missing\"></p>\n</div>",
+	},
+}
+
+func TestProcessHTMLWithCSSModules_MissingClassPolicy(t *testing.T) {
+	payload := "<div css-module=\"test-1 test-2\">\n  <p css-module=\"test-3  test-4\"></p>\n</div>"
+	expectedMissing := []MissingClass{
+		{Pos: Position{Offset: 24, Line: 1, Column: 25}, Name: "test-2", Err: ErrClassNotFound},
+		{Pos: Position{Offset: 50, Line: 2, Column: 18}, Name: "test-3", Err: ErrClassNotFound},
+		{Pos: Position{Offset: 58, Line: 2, Column: 26}, Name: "test-4", Err: ErrClassNotFound},
+	}
+	classes := map[string]string{"test-1": "RAN_1"}
+	for i := range testCasesMissingClassPolicy {
+		tc := testCasesMissingClassPolicy[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			resultingHTML, err := ProcessHTMLWithCSSModules(strings.NewReader(payload), classes, tc.opts...)
+			var missingErr *MissingClassesError
+			if !errors.As(err, &missingErr) || !errors.Is(err, ErrClassNotFound) {
+				t.Errorf("unexpected error value: expected *MissingClassesError got %v", err)
+				return
+			}
+			if !reflect.DeepEqual(missingErr.Missing, expectedMissing) {
+				t.Errorf("unexpected missing classes: expected %v got %v", expectedMissing, missingErr.Missing)
+			}
+			if string(resultingHTML) != tc.expectedHTML {
+				t.Errorf("unexpected html value: expected %s got %s", tc.expectedHTML, resultingHTML)
+			}
+
+			// ParseTo writes the whole document too
+			buf := &bytes.Buffer{}
+			err = NewHTMLCSSModulesParser(strings.NewReader(payload), classes, tc.opts...).ParseTo(buf)
+			if !errors.As(err, &missingErr) {
+				t.Errorf("unexpected error value: expected *MissingClassesError got %v", err)
+				return
+			}
+			if buf.String() != tc.expectedHTML {
+				t.Errorf("unexpected html value: expected %s got %s", tc.expectedHTML, buf.String())
+			}
+		})
+	}
+}
+
+func TestMissingClassesError_Error(t *testing.T) {
+	err := &MissingClassesError{Missing: []MissingClass{
+		{Pos: Position{Line: 1, Column: 24}, Name: "test-2"},
+		{Pos: Position{Line: 2, Column: 20}, Name: "test-3"},
+	}}
+	expected := "css modules class not found: test-2 (at 1:24), test-3 (at 2:20)"
+	if err.Error() != expected {
+		t.Errorf("unexpected error message: expected %q got %q", expected, err.Error())
+	}
+}
+
+func TestFuncMap_MissingClassPolicy(t *testing.T) {
+	funcs := FuncMap(map[string]string{"test-1": "RAN_1"}, WithMissingClassPolicy(MissingClassPlaceholder))
+	tmpl := template.Must(template.New("").Funcs(funcs).Parse(`<div {{cssclass "test-1" "test-2"}}></div>`))
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, nil); err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	expectedHTML := `<div class="RAN_1 css-module-missing"></div>`
+	if sb.String() != expectedHTML {
+		t.Errorf("unexpected html value: expected %s got %s", expectedHTML, sb.String())
+	}
+}
@@ -0,0 +1,131 @@
+package cssmodules
+
+import (
+	"strings"
+
+	html_parser "golang.org/x/net/html"
+)
+
+// ApplyToNode processes the tree rooted at root in place, like
+// ProcessHTMLWithCSSModules does with a document: the scoped classes of the
+// `css-module` attributes are merged into the `class` attributes, the
+// `css-module` and `css-module-scope` attributes are removed. The classes are
+// resolved with resolver, or with the resolver of the options if it's nil.
+//
+// The errors are the same as the ones of ProcessHTMLWithCSSModules, but the
+// positions of the missing classes are zero because the nodes don't keep them.
+// The tree is processed completely along with a *MissingClassesError.
+func ApplyToNode(root *html_parser.Node, resolver ClassResolver, opts ...Option) error {
+	cfg := newConfig(opts)
+	if resolver == nil {
+		resolver = cfg.resolverFor(nil)
+	}
+	p := newHTMLProcessor(cfg)
+	if err := p.applyToNode(root, resolver); err != nil {
+		return err
+	}
+	return p.missingErr()
+}
+
+// applyToNode processes n and its descendants with the resolver r.
+func (p *htmlProcessor) applyToNode(n *html_parser.Node, r ClassResolver) error {
+	if n.Type == html_parser.ElementNode {
+		var err error
+		if r, err = p.applyToElement(n, r); err != nil {
+			return err
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if err := p.applyToNode(c, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyToElement processes the attributes of the element n and returns the
+// resolver of its descendants.
+func (p *htmlProcessor) applyToElement(n *html_parser.Node, r ClassResolver) (ClassResolver, error) {
+	firstSource, target, scope := -1, -1, -1
+	for i, a := range n.Attr {
+		key := []byte(a.Key)
+		switch {
+		case a.Namespace != "":
+		case p.cfg.isSourceAttr(key):
+			if firstSource == -1 {
+				firstSource = i
+			}
+		case scope == -1 && p.cfg.equalName(key, scopeAttr):
+			scope = i
+		case target == -1 && p.cfg.isTargetAttr(key):
+			target = i
+		}
+	}
+	if scope != -1 {
+		module, ok := p.cfg.modules[n.Attr[scope].Val]
+		if !ok {
+			return nil, ErrModuleNotFound
+		}
+		r = module
+	}
+	if firstSource == -1 && scope == -1 {
+		return r, nil
+	}
+
+	p.names = p.names[:0]
+	for i := firstSource; i != -1 && i < len(n.Attr); i++ {
+		a := &n.Attr[i]
+		if a.Namespace != "" || !p.cfg.isSourceAttr([]byte(a.Key)) {
+			continue
+		}
+		for val := []byte(a.Val); len(val) != 0; {
+			c := val
+			if i := p.cfg.indexSeparator(val); i != -1 {
+				c, val = val[:i], val[i+1:]
+			} else {
+				val = nil
+			}
+			if len(c) == 0 {
+				continue
+			}
+			var err error
+			if p.names, err = p.cfg.appendClasses(p.names, r, c); err != nil {
+				class, err := p.recordMissing(c, Position{}, err)
+				if err != nil {
+					return nil, err
+				}
+				p.appendMissing(class)
+			}
+		}
+	}
+
+	attrs := make([]html_parser.Attribute, 0, len(n.Attr)+1)
+	for i, a := range n.Attr {
+		switch {
+		case i == target && firstSource != -1:
+			a.Val = p.mergeNodeClasses(strings.TrimSpace(a.Val))
+		case i == scope || (a.Namespace == "" && p.cfg.isSourceAttr([]byte(a.Key))):
+			keep := p.cfg.merge.KeepSource && i != scope
+			if keep {
+				attrs = append(attrs, a)
+			}
+			if i != firstSource || target != -1 {
+				continue
+			}
+			// The target attribute takes the place of the first source
+			// attribute, or follows it if it's kept
+			a = html_parser.Attribute{Key: p.cfg.targetAttr, Val: p.mergeNodeClasses("")}
+		}
+		attrs = append(attrs, a)
+	}
+	n.Attr = attrs
+	return r, nil
+}
+
+// mergeNodeClasses returns the classes of the target attribute val merged with
+// p.names.
+func (p *htmlProcessor) mergeNodeClasses(val string) string {
+	sb := strings.Builder{}
+	p.mergeClasses(&sb, []byte(val))
+	return sb.String()
+}
@@ -0,0 +1,90 @@
+package cssmodules
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	html_parser "golang.org/x/net/html"
+)
+
+func TestApplyToNode(t *testing.T) {
+	classes := ClassMap{"card": "RAN_1", "title": "RAN_2"}
+	testCases := []struct {
+		name     string
+		opts     []Option
+		payload  string
+		expected string
+	}{
+		{
+			name:     "Merge",
+			payload:  `<div class=" box " css-module="card"><h1 css-module="title" id="t">Hi</h1><p>x</p></div>`,
+			expected: `<div class="box RAN_1"><h1 class="RAN_2" id="t">Hi</h1><p>x</p></div>`,
+		},
+		{
+			name:     "SeveralSources",
+			opts:     []Option{WithSourceAttributes("css-module", "data-css-module")},
+			payload:  `<div css-module="card" data-css-module="title" class="box"></div>`,
+			expected: `<div class="box RAN_1 RAN_2"></div>`,
+		},
+		{
+			name:     "Scope",
+			opts:     []Option{WithModule("button", map[string]string{"root": "BTN_1"})},
+			payload:  `<nav css-module-scope="button"><a css-module="root"></a></nav><a css-module="card"></a>`,
+			expected: `<nav><a class="BTN_1"></a></nav><a class="RAN_1"></a>`,
+		},
+		{
+			name:     "Template",
+			payload:  `<div><template><b css-module="title"></b></template></div>`,
+			expected: `<div><template><b class="RAN_2"></b></template></div>`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root, err := html_parser.Parse(strings.NewReader(tc.payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ApplyToNode(root, classes, tc.opts...); err != nil {
+				t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+			}
+			sb := strings.Builder{}
+			if err := html_parser.Render(&sb, root); err != nil {
+				t.Fatal(err)
+			}
+			expected := `<html><head></head><body>` + tc.expected + `</body></html>`
+			if sb.String() != expected {
+				t.Errorf("unexpected html value: expected\n%s\ngot\n%s", expected, sb.String())
+			}
+		})
+	}
+}
+
+func TestApplyToNode_Missing(t *testing.T) {
+	root, err := html_parser.Parse(strings.NewReader(`<p css-module="card unknown other"></p>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyToNode(root, ClassMap{"card": "RAN_1"}); !errors.Is(err, ErrClassNotFound) {
+		t.Errorf("unexpected error value: expected %v got %v", ErrClassNotFound, err)
+	}
+
+	err = ApplyToNode(root, ClassMap{"card": "RAN_1"}, WithMissingClassPolicy(MissingClassPlaceholder))
+	var missingErr *MissingClassesError
+	if !errors.As(err, &missingErr) || len(missingErr.Missing) != 2 || missingErr.Missing[0].Name != "unknown" {
+		t.Fatalf("unexpected error value: expected *MissingClassesError got %v", err)
+	}
+	sb := strings.Builder{}
+	if err := html_parser.Render(&sb, root); err != nil {
+		t.Fatal(err)
+	}
+	expected := `<html><head></head><body><p class="RAN_1 css-module-missing"></p></body></html>`
+	if sb.String() != expected {
+		t.Errorf("unexpected html value: expected\n%s\ngot\n%s", expected, sb.String())
+	}
+
+	root, _ = html_parser.Parse(strings.NewReader(`<p css-module-scope="unknown"></p>`))
+	if err := ApplyToNode(root, ClassMap{}); !errors.Is(err, ErrModuleNotFound) {
+		t.Errorf("unexpected error value: expected %v got %v", ErrModuleNotFound, err)
+	}
+}
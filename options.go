@@ -0,0 +1,197 @@
+package cssmodules
+
+import (
+	"bytes"
+	"io/fs"
+)
+
+// Mode controls which selectors are scoped when processing CSS.
+type Mode int
+
+const (
+	// ModeLocal scopes every class selector unless it is wrapped in a
+	// `:global` block or a `:global(...)` selector. This is the default.
+	ModeLocal Mode = iota
+
+	// ModeGlobal leaves every class selector untouched unless it is wrapped in
+	// `:local(...)` or follows a `:local` pseudo-class in the same selector.
+	// Useful for migrating legacy global stylesheets gradually.
+	ModeGlobal
+)
+
+// Option configures the processing done by the parsers and Process functions
+// of this package.
+type Option func(*config)
+
+type config struct {
+	mode             Mode
+	localsConvention LocalsConvention
+	safeMode         *SafeMode
+
+	keyframesScoping        bool
+	customPropertiesScoping bool
+
+	// HTML processing
+	sourceAttrs    [][]byte
+	targetAttr     string
+	delims         delims
+	dynamicClasses bool
+	styleModules   bool
+	idScoping      bool
+	styleRewriting bool
+	xml            bool
+	modules        Modules
+	resolver       ClassResolver
+	// alias is the alias of the module resolving the classes of the document,
+	// used by the actions of the dynamic classes, see withDocumentAlias
+	alias string
+	linkFS         fs.FS
+	manifest       *Manifest
+
+	merge            MergePolicy
+	missingPolicy    MissingClassPolicy
+	placeholderClass string
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		sourceAttrs: [][]byte{[]byte("css-module")},
+		targetAttr:  "class",
+		delims:      delims{left: []byte("{{"), right: []byte("}}")},
+
+		placeholderClass: DefaultPlaceholderClass,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.safeMode != nil {
+		c.mode = ModeLocal
+		c.keyframesScoping = true
+	}
+	return c
+}
+
+// WithMode sets the scoping mode used when processing CSS, see ModeLocal and
+// ModeGlobal.
+func WithMode(mode Mode) Option {
+	return func(c *config) {
+		c.mode = mode
+	}
+}
+
+// WithLocalsConvention sets the keys used for the key-value pairs of classes and
+// scoped classes. The HTML processor uses it to accept both spellings of a class
+// in the `css-module` attribute.
+func WithLocalsConvention(lc LocalsConvention) Option {
+	return func(c *config) {
+		c.localsConvention = lc
+	}
+}
+
+// WithSafeMode enables the safe mode for processing untrusted CSS, see SafeMode.
+func WithSafeMode(sm SafeMode) Option {
+	return func(c *config) {
+		c.safeMode = &sm
+	}
+}
+
+// WithSourceAttributes sets the names of the attributes that the HTML processor
+// reads the classes from, `css-module` by default. The names are case
+// insensitive.
+func WithSourceAttributes(names ...string) Option {
+	return func(c *config) {
+		c.sourceAttrs = c.sourceAttrs[:0:0]
+		for _, name := range names {
+			c.sourceAttrs = append(c.sourceAttrs, []byte(name))
+		}
+	}
+}
+
+// WithTargetAttribute sets the name of the attribute that the HTML processor
+// writes the scoped classes to, `class` by default. The classes already in the
+// attribute are kept.
+func WithTargetAttribute(name string) Option {
+	return func(c *config) {
+		c.targetAttr = name
+	}
+}
+
+func (c *config) isSourceAttr(key []byte) bool {
+	for _, name := range c.sourceAttrs {
+		if c.equalName(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *config) isTargetAttr(key []byte) bool {
+	return c.equalName(key, []byte(c.targetAttr))
+}
+
+// equalName compares the names of tags and attributes, which are case
+// sensitive only in XML.
+func (c *config) equalName(a, b []byte) bool {
+	if c.xml {
+		return bytes.Equal(a, b)
+	}
+	return bytes.EqualFold(a, b)
+}
+
+// WithXML makes the HTML processor follow the rules of XML, for documents served
+// as XHTML or SVG files. The names of the tags and attributes are case
+// sensitive, every element can be closed with `/>` and have descendants, the
+// `<script>` and `<style>` elements can contain tags, and CDATA sections are
+// copied as they are.
+func WithXML() Option {
+	return func(c *config) {
+		c.xml = true
+	}
+}
+
+// WithTemplateDelims sets the delimiters of the template actions that the HTML
+// processor leaves untouched, `{{` and `}}` by default. Like in text/template,
+// an empty delimiter stands for the default one.
+func WithTemplateDelims(left, right string) Option {
+	return func(c *config) {
+		if left == "" {
+			left = "{{"
+		}
+		if right == "" {
+			right = "}}"
+		}
+		c.delims = delims{left: []byte(left), right: []byte(right)}
+	}
+}
+
+// WithModule registers the scoped classes of a module under alias, so the HTML
+// processor and the template functions resolve the classes written as
+// `alias:class`. A `css-module-scope="alias"` attribute makes the module the
+// default one for the element and its descendants.
+func WithModule(alias string, scopedClasses map[string]string) Option {
+	return func(c *config) {
+		if c.modules == nil {
+			c.modules = Modules{}
+		}
+		c.modules[alias] = ClassMap(scopedClasses)
+	}
+}
+
+// withDocumentAlias sets the alias of the module of the document, so the
+// actions of the dynamic classes are resolved with it by the cssmodulein
+// template function. The module is registered with withModuleResolver.
+func withDocumentAlias(alias string) Option {
+	return func(c *config) {
+		c.alias = alias
+	}
+}
+
+// withModuleResolver registers r under alias, like WithModule.
+func withModuleResolver(alias string, r ClassResolver) Option {
+	return func(c *config) {
+		if c.modules == nil {
+			c.modules = Modules{}
+		}
+		c.modules[alias] = r
+	}
+}
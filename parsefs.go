@@ -0,0 +1,124 @@
+package cssmodules
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// ParseFS is like template.ParseFS but pairs every template with its sibling
+// CSS module, `card.module.css` for `card.gohtml`. The modules are processed
+// with ProcessCSSModules, the `css-module` attributes of the templates are
+// processed with the classes of their module before parsing them, and the CSS
+// processed of every module is returned concatenated. Templates without a
+// sibling module are parsed as they are unless they have a `css-module`
+// attribute. The options are used for processing both the CSS and the HTML, the
+// classes not found in a module are resolved with the resolver of WithResolver.
+//
+// The templates are parsed into t, like (*template.Template).ParseFS, and the
+// functions of FuncMap are added to t, resolving the classes with the resolver
+// of the options. The module of every template is registered under the name
+// of the template without extension, so the functions resolve its classes as
+// `card:root` or with {{cssmodulein "card" .Variant}}, and the dynamic classes
+// of WithDynamicClasses are resolved with the module of their template. Other
+// functions called by the templates must be added to t beforehand. If t is nil
+// the first template is the one returned.
+func ParseFS(t *template.Template, fsys fs.FS, patterns []string, opts ...Option) (*template.Template, []byte, error) {
+	files, err := globFS(fsys, patterns)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg := newConfig(opts)
+
+	css := bytes.Buffer{}
+	// modules maps the paths of the modules to their classes, so a module
+	// shared by several templates is processed once
+	modules := map[string]map[string]string{}
+	fileClasses := make([]map[string]string, len(files))
+	funcOpts := append([]Option(nil), opts...)
+	for i, file := range files {
+		modulePath := strings.TrimSuffix(file, path.Ext(file)) + ".module.css"
+		classes, ok := modules[modulePath]
+		if !ok {
+			module, err := fsys.Open(modulePath)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, nil, err
+			}
+			classes = map[string]string{}
+			if err == nil {
+				var processed []byte
+				processed, classes, err = ProcessCSSModules(module, opts...)
+				module.Close()
+				if err != nil {
+					return nil, nil, fmt.Errorf("cssmodules: %s: %w", modulePath, err)
+				}
+				css.Write(processed)
+				css.WriteByte('\n')
+			}
+			modules[modulePath] = classes
+		}
+		fileClasses[i] = classes
+		funcOpts = append(funcOpts, withModuleResolver(templateAlias(file), cfg.resolverFor(classes)))
+	}
+
+	funcs := FuncMap(nil, funcOpts...)
+	for i, file := range files {
+		src, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, nil, err
+		}
+		htmlOpts := append(funcOpts[:len(funcOpts):len(funcOpts)], withDocumentAlias(templateAlias(file)))
+		processed, err := ProcessHTMLWithCSSModules(bytes.NewReader(src), fileClasses[i], htmlOpts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cssmodules: %s: %w", file, err)
+		}
+
+		// The templates are named after the base of their path, like in
+		// template.ParseFS
+		name := path.Base(file)
+		var tmpl *template.Template
+		if t == nil {
+			t = template.New(name)
+		}
+		if i == 0 {
+			t.Funcs(funcs)
+		}
+		if name == t.Name() {
+			tmpl = t
+		} else {
+			tmpl = t.New(name)
+		}
+		if _, err := tmpl.Parse(string(processed)); err != nil {
+			return nil, nil, err
+		}
+	}
+	return t, css.Bytes(), nil
+}
+
+// templateAlias returns the alias of the module of a template, its name without
+// extension.
+func templateAlias(file string) string {
+	base := path.Base(file)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// globFS returns the files matching the patterns, failing if a pattern doesn't
+// match any file.
+func globFS(fsys fs.FS, patterns []string) ([]string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		list, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(list) == 0 {
+			return nil, fmt.Errorf("cssmodules: pattern matches no files: %#q", pattern)
+		}
+		files = append(files, list...)
+	}
+	return files, nil
+}
@@ -0,0 +1,98 @@
+package cssmodules
+
+import (
+	"errors"
+	"html/template"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"components/card.gohtml":       {Data: []byte(`<div css-module="card">{{template "button.gohtml"}}</div>`)},
+		"components/card.module.css":   {Data: []byte(`.card{color:red}`)},
+		"components/button.gohtml":     {Data: []byte(`<button class="reset" css-module="btn">Ok</button>`)},
+		"components/button.module.css": {Data: []byte(`.btn{color:blue}`)},
+		"components/plain.gohtml":      {Data: []byte(`<p>{{.}}</p>`)},
+	}
+	tmpl, css, err := ParseFS(nil, fsys, []string{"components/*.gohtml"})
+	if err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+
+	scopedClasses := map[string]string{}
+	for _, m := range regexp.MustCompile(`\.(_([a-z]+)_[\w-]{6})`).FindAllStringSubmatch(string(css), -1) {
+		scopedClasses[m[2]] = m[1]
+	}
+	expectedCSS := os.Expand(".${btn}{color:blue}\n.${card}{color:red}\n", func(s string) string { return scopedClasses[s] })
+	if string(css) != expectedCSS {
+		t.Errorf("unexpected css value: expected %q got %q", expectedCSS, css)
+	}
+
+	var sb strings.Builder
+	if err := tmpl.ExecuteTemplate(&sb, "card.gohtml", nil); err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	expectedHTML := os.Expand(`<div class="${card}"><button class="reset ${btn}">Ok</button></div>`, func(s string) string { return scopedClasses[s] })
+	if sb.String() != expectedHTML {
+		t.Errorf("unexpected html value: expected %s got %s", expectedHTML, sb.String())
+	}
+	if tmpl.Lookup("plain.gohtml") == nil {
+		t.Errorf("unexpected template value: expected plain.gohtml got <nil>")
+	}
+}
+
+func TestParseFS_ClassNotFound(t *testing.T) {
+	fsys := fstest.MapFS{
+		"card.gohtml":     {Data: []byte(`<div css-module="btn"></div>`)},
+		"card.module.css": {Data: []byte(`.card{color:red}`)},
+	}
+	if _, _, err := ParseFS(nil, fsys, []string{"*.gohtml"}); !errors.Is(err, ErrClassNotFound) {
+		t.Errorf("unexpected error value: expected %v got %v", ErrClassNotFound, err)
+	}
+	if _, _, err := ParseFS(nil, fsys, []string{"*.html"}); err == nil {
+		t.Errorf("unexpected error value: expected pattern error got <nil>")
+	}
+}
+
+func TestParseFS_Funcs(t *testing.T) {
+	fsys := fstest.MapFS{
+		"card.gohtml":       {Data: []byte(`<div css-module="card text {{.Variant}}"><p {{cssclass "text"}}></p>{{cssmodulein "button" "btn"}}</div>`)},
+		"card.module.css":   {Data: []byte(`.card{color:red}.big{color:blue}`)},
+		"button.gohtml":     {Data: []byte(`<button css-module="btn {{.}}"></button>`)},
+		"button.module.css": {Data: []byte(`.btn{color:green}`)},
+	}
+	// The classes of the modules are resolved with the module of their
+	// template, the shared classes with the resolver
+	shared := WithResolver(ClassMap{"text": "_text_abc", "big": "_big_abc"})
+	tmpl, css, err := ParseFS(template.New(""), fsys, []string{"*.gohtml"}, WithDynamicClasses(), shared)
+	if err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	scopedClasses := map[string]string{}
+	for _, m := range regexp.MustCompile(`\.(_([a-z]+)_[\w-]{6})`).FindAllStringSubmatch(string(css), -1) {
+		scopedClasses[m[2]] = m[1]
+	}
+	expand := func(s string) string { return scopedClasses[s] }
+
+	var sb strings.Builder
+	if err := tmpl.ExecuteTemplate(&sb, "card.gohtml", map[string]string{"Variant": "big"}); err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	expectedHTML := os.Expand(`<div class="${card} _text_abc ${big}"><p class="_text_abc"></p>${btn}</div>`, expand)
+	if sb.String() != expectedHTML {
+		t.Errorf("unexpected html value: expected %s got %s", expectedHTML, sb.String())
+	}
+
+	sb.Reset()
+	if err := tmpl.ExecuteTemplate(&sb, "button.gohtml", "text"); err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	expectedHTML = os.Expand(`<button class="${btn} _text_abc"></button>`, expand)
+	if sb.String() != expectedHTML {
+		t.Errorf("unexpected html value: expected %s got %s", expectedHTML, sb.String())
+	}
+}
@@ -0,0 +1,80 @@
+package cssmodules
+
+import (
+	"io"
+)
+
+// Reader is an io.ReadCloser returning the CSS processed of a stylesheet. The
+// stylesheet is processed while the Reader is read, so the CSS processed is
+// never held in memory as a whole. The stylesheet itself is read at once by the
+// CSS lexer.
+type Reader struct {
+	pr      *io.PipeReader
+	done    chan struct{}
+	classes map[string]string
+}
+
+// NewReader returns a Reader that processes the CSS read from css.
+//
+// The Reader must be read until it returns an error, or closed, for the
+// processing goroutine to finish.
+func NewReader(css io.Reader, opts ...Option) *Reader {
+	pr, pw := io.Pipe()
+	r := &Reader{pr: pr, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		classes, err := NewCSSModulesParser(css, opts...).ParseTo(pw)
+		r.classes = classes
+		pw.CloseWithError(err)
+	}()
+	return r
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	return r.pr.Read(p)
+}
+
+// Close stops the processing of the stylesheet. The processing goroutine
+// returns at the next token, once writing the CSS processed fails.
+func (r *Reader) Close() error {
+	return r.pr.Close()
+}
+
+// Classes returns the key-value pair of the classes and scoped classes. It must
+// be called after Read returned io.EOF, it returns nil if the processing
+// failed.
+func (r *Reader) Classes() map[string]string {
+	<-r.done
+	return r.classes
+}
+
+// HTMLReader is an io.ReadCloser returning the HTML processed of a document.
+// The document is processed while the HTMLReader is read, so neither the HTML
+// nor the HTML processed are held in memory as a whole.
+type HTMLReader struct {
+	pr *io.PipeReader
+}
+
+// NewHTMLReader returns an HTMLReader that processes the HTML read from html
+// with the scoped classes.
+//
+// The HTMLReader must be read until it returns an error, or closed, for the
+// processing goroutine to finish.
+func NewHTMLReader(html io.Reader, scopedClasses map[string]string, opts ...Option) *HTMLReader {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(NewHTMLCSSModulesParser(html, scopedClasses, opts...).ParseTo(pw))
+	}()
+	return &HTMLReader{pr: pr}
+}
+
+func (r *HTMLReader) Read(p []byte) (int, error) {
+	return r.pr.Read(p)
+}
+
+// Close stops the processing of the document. The processing goroutine returns
+// at the next token, once writing the HTML processed fails, without reading the
+// rest of the document.
+func (r *HTMLReader) Close() error {
+	return r.pr.Close()
+}
@@ -0,0 +1,90 @@
+package cssmodules
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/tdewolff/parse/v2"
+)
+
+func TestReader(t *testing.T) {
+	r := NewReader(strings.NewReader(`.test-1{color:red} @media screen{.test-2{color:blue}}`))
+	css, err := io.ReadAll(r)
+	if err != nil {
+		t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+		return
+	}
+	scopedClasses := r.Classes()
+	expected := os.Expand(`.${test-1}{color:red} @media screen{.${test-2}{color:blue}}`, func(s string) string { return scopedClasses[s] })
+	if len(scopedClasses) != 2 || string(css) != expected {
+		t.Errorf("unexpected css value: expected\n%q\ngot\n%q with %q map", expected, css, scopedClasses)
+	}
+}
+
+func TestReader_Close(t *testing.T) {
+	r := NewReader(strings.NewReader(strings.Repeat(`.test-1{color:red}`, 10000)))
+	buf := make([]byte, 10)
+	if _, err := r.Read(buf); err != nil {
+		t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+		return
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+		return
+	}
+	if classes := r.Classes(); classes != nil {
+		t.Errorf("unexpected classes value: expected <nil> got %q", classes)
+		return
+	}
+
+	// The processing stops at the first write failing after Close, before the
+	// end of the stylesheet
+	pr, pw := io.Pipe()
+	pr.Close()
+	in := parse.NewInputString(strings.Repeat(`.test-1{color:red}`, 10000))
+	bw := bufio.NewWriterSize(pw, streamBufferSize)
+	if _, err := newCSSProcessor(newConfig(nil)).process(context.Background(), in, bw); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("unexpected error value: expected %v got %v", io.ErrClosedPipe, err)
+		return
+	}
+	if in.Offset() >= in.Len() {
+		t.Errorf("unexpected offset value: expected less than %d got %d", in.Len(), in.Offset())
+	}
+}
+
+func TestHTMLReader_Close(t *testing.T) {
+	pr, pw := io.Pipe()
+	pr.Close()
+	// The document isn't read after the first write failing
+	html := strings.NewReader(strings.Repeat(`<div css-module="test-1"></div>`, 10000))
+	err := NewHTMLCSSModulesParser(html, map[string]string{"test-1": "_test-1_abc"}).ParseTo(pw)
+	if !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("unexpected error value: expected %v got %v", io.ErrClosedPipe, err)
+		return
+	}
+	if html.Len() == 0 {
+		t.Errorf("unexpected html value: expected the document to be partially read")
+	}
+}
+
+func TestHTMLReader(t *testing.T) {
+	for i := range testCasesHTMLCSSModules {
+		tc := testCasesHTMLCSSModules[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			resultingHTML, err := io.ReadAll(NewHTMLReader(strings.NewReader(tc.payload), tc.cssModulesClasses))
+			if err != nil {
+				t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+				return
+			}
+			if string(resultingHTML) != tc.expectedHTML {
+				t.Errorf("unexpected html value: expected %s got %s", tc.expectedHTML, resultingHTML)
+			}
+		})
+	}
+}
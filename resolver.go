@@ -0,0 +1,125 @@
+package cssmodules
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+// ClassResolver resolves the classes written in the `css-module` attributes and
+// the template functions to their scoped classes. Resolve returns an error
+// wrapping ErrClassNotFound for unknown classes.
+//
+// The map of the scoped classes passed to the HTML processor is a ClassMap,
+// other resolvers are used with WithResolver.
+type ClassResolver interface {
+	Resolve(name string) ([]string, error)
+}
+
+// ClassMap is the key-value pair of classes and scoped classes returned by
+// ProcessCSSModules.
+type ClassMap map[string]string
+
+func (m ClassMap) Resolve(name string) ([]string, error) {
+	class, exists := m[name]
+	if !exists {
+		return nil, ErrClassNotFound
+	}
+	return []string{class}, nil
+}
+
+// ResolverFunc is an adapter to use functions as ClassResolvers.
+type ResolverFunc func(name string) ([]string, error)
+
+func (f ResolverFunc) Resolve(name string) ([]string, error) {
+	return f(name)
+}
+
+// ChainResolver resolves the classes with the first resolver that knows them,
+// trying the next one only if a resolver returns an error wrapping
+// ErrClassNotFound. Useful for falling back from a module to a set of global
+// classes.
+type ChainResolver []ClassResolver
+
+func (c ChainResolver) Resolve(name string) ([]string, error) {
+	for _, r := range c {
+		classes, err := r.Resolve(name)
+		if !errors.Is(err, ErrClassNotFound) {
+			return classes, err
+		}
+	}
+	return nil, ErrClassNotFound
+}
+
+// Modules is a registry of modules keyed by their alias, resolving the classes
+// written as `alias:class`. The classes without alias are resolved with the
+// module registered under the empty alias, if any.
+type Modules map[string]ClassResolver
+
+func (m Modules) Resolve(name string) ([]string, error) {
+	alias, class, ok := strings.Cut(name, ":")
+	if !ok {
+		alias, class = "", name
+	}
+	module, exists := m[alias]
+	if !exists {
+		if !ok {
+			return nil, ErrClassNotFound
+		}
+		return nil, ErrModuleNotFound
+	}
+	return module.Resolve(class)
+}
+
+// WithResolver sets the resolver of the classes used by the HTML processor and
+// the template functions. When a map of scoped classes is passed to them too,
+// the classes are resolved with the map first and with r if the map doesn't
+// have them, like with ChainResolver. The map can be nil.
+func WithResolver(r ClassResolver) Option {
+	return func(c *config) {
+		c.resolver = r
+	}
+}
+
+// resolverFor returns the resolver of scopedClasses chained with the resolver of
+// the options, if any.
+func (c *config) resolverFor(scopedClasses map[string]string) ClassResolver {
+	if c.resolver == nil {
+		return ClassMap(scopedClasses)
+	}
+	if scopedClasses == nil {
+		return c.resolver
+	}
+	return ChainResolver{ClassMap(scopedClasses), c.resolver}
+}
+
+// appendClasses appends the scoped classes of name resolved with r to dst, or
+// with the module registered under the alias prefixing name as in `card:root`.
+// The lookups in a ClassMap don't allocate.
+func (c *config) appendClasses(dst []string, r ClassResolver, name []byte) ([]string, error) {
+	if i := bytes.IndexByte(name, ':'); i != -1 && len(c.modules) != 0 {
+		module, ok := c.modules[string(name[:i])]
+		if !ok {
+			return dst, ErrModuleNotFound
+		}
+		r, name = module, name[i+1:]
+	}
+	if m, ok := r.(ClassMap); ok {
+		class, exists := m[string(name)]
+		if !exists && c.localsConvention != LocalsAsIs {
+			class, exists = m[c.localsConvention.convert(string(name))]
+		}
+		if !exists {
+			return dst, ErrClassNotFound
+		}
+		return append(dst, class), nil
+	}
+	classes, err := r.Resolve(string(name))
+	if errors.Is(err, ErrClassNotFound) && c.localsConvention != LocalsAsIs {
+		classes, err = r.Resolve(c.localsConvention.convert(string(name)))
+	}
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, classes...), nil
+}
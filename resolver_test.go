@@ -0,0 +1,116 @@
+package cssmodules
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var testCasesClassResolver = []struct {
+	name         string
+	resolver     ClassResolver
+	payload      string
+	expectedHTML string
+	expectedErr  error
+}{
+	{
+		name:         "ClassMap",
+		resolver:     ClassMap{"test-1": "RAN_1"},
+		expectedHTML: `<div class="RAN_1"></div>`,
+
+		payload: `<div css-module="test-1"></div>`,
+	},
+	{
+		name: "ResolverFunc",
+		resolver: ResolverFunc(func(name string) ([]string, error) {
+			return []string{"_" + name, "_" + name + "_base"}, nil
+		}),
+		expectedHTML: `<div class="foo _test-1 _test-1_base"></div>`,
+
+		payload: `<div class="foo" css-module="test-1"></div>`,
+	},
+	{
+		name:         "ChainResolver",
+		resolver:     ChainResolver{ClassMap{"test-1": "RAN_1"}, ClassMap{"mt-2": "mt-2"}},
+		expectedHTML: `<div class="RAN_1 mt-2"></div>`,
+
+		payload: `<div css-module="test-1 mt-2"></div>`,
+	},
+	{
+		name:        "ChainResolverNotFound",
+		resolver:    ChainResolver{ClassMap{"test-1": "RAN_1"}, ClassMap{"mt-2": "mt-2"}},
+		expectedErr: ErrClassNotFound,
+
+		payload: `<div css-module="test-2"></div>`,
+	},
+	{
+		name: "ChainResolverError",
+		resolver: ChainResolver{
+			ResolverFunc(func(name string) ([]string, error) { return nil, ErrModuleNotFound }),
+			ClassMap{"test-1": "RAN_1"},
+		},
+		expectedErr: ErrModuleNotFound,
+
+		payload: `<div css-module="test-1"></div>`,
+	},
+	{
+		name:         "Modules",
+		resolver:     Modules{"": ClassMap{"test-1": "RAN_1"}, "card": ClassMap{"root": "CARD_1"}},
+		expectedHTML: `<div class="RAN_1 CARD_1"></div>`,
+
+		payload: `<div css-module="test-1 card:root"></div>`,
+	},
+	{
+		name:        "ModulesNotFound",
+		resolver:    Modules{"card": ClassMap{"root": "CARD_1"}},
+		expectedErr: ErrModuleNotFound,
+
+		payload: `<div css-module="header:root"></div>`,
+	},
+}
+
+func TestProcessHTMLWithCSSModules_Resolver(t *testing.T) {
+	for i := range testCasesClassResolver {
+		tc := testCasesClassResolver[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			resultingHTML, err := ProcessHTMLWithCSSModules(strings.NewReader(tc.payload), nil, WithResolver(tc.resolver))
+			if !errors.Is(err, tc.expectedErr) {
+				t.Errorf("unexpected error value: expected %v got %v", tc.expectedErr, err)
+				return
+			}
+			if string(resultingHTML) != tc.expectedHTML {
+				t.Errorf("unexpected html value: expected %s got %s", tc.expectedHTML, resultingHTML)
+			}
+		})
+	}
+}
+
+func TestProcessHTMLWithCSSModules_ResolverWithMap(t *testing.T) {
+	// The map is looked up first, the resolver resolves the classes the map
+	// doesn't have
+	scopedClasses := map[string]string{"test-1": "MAP_1"}
+	resolver := WithResolver(ClassMap{"test-1": "RAN_1", "test-2": "RAN_2"})
+	payload := `<div css-module="test-1 test-2"></div>`
+	expectedHTML := `<div class="MAP_1 RAN_2"></div>`
+	resultingHTML, err := ProcessHTMLWithCSSModules(strings.NewReader(payload), scopedClasses, resolver)
+	if err != nil {
+		t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+		return
+	}
+	if string(resultingHTML) != expectedHTML {
+		t.Errorf("unexpected html value: expected %s got %s", expectedHTML, resultingHTML)
+	}
+	var sb strings.Builder
+	if err := NewHTMLCSSModulesParser(strings.NewReader(payload), scopedClasses, resolver).ParseTo(&sb); err != nil {
+		t.Errorf("unexpected error value: expected <nil> got %q", err.Error())
+		return
+	}
+	if sb.String() != expectedHTML {
+		t.Errorf("unexpected html value: expected %s got %s", expectedHTML, sb.String())
+	}
+	_, err = ProcessHTMLWithCSSModules(strings.NewReader(`<div css-module="test-3"></div>`), scopedClasses, resolver)
+	if !errors.Is(err, ErrClassNotFound) {
+		t.Errorf("unexpected error value: expected %v got %v", ErrClassNotFound, err)
+	}
+}
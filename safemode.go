@@ -0,0 +1,792 @@
+package cssmodules
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/tdewolff/parse/v2"
+	css_parser "github.com/tdewolff/parse/v2/css"
+)
+
+// Default limits of the safe mode, used when the limits of SafeMode are 0.
+const (
+	DefaultMaxInputSize = 1 << 20
+	DefaultMaxTokens    = 1 << 17
+	DefaultMaxDepth     = 16
+)
+
+// SafeMode configures the hardened processing of untrusted CSS, for example CSS
+// uploaded by the users of an application. In safe mode:
+//
+//   - The size, the number of tokens and the nesting depth of the stylesheet are
+//     limited, exceeding them is an error wrapping ErrLimitExceeded.
+//   - `@import` rules and remote `url()` values are removed.
+//   - The at-rules defining names shared with the page, `@font-face`,
+//     `@property`, `@counter-style`, `@font-feature-values`,
+//     `@font-palette-values` and `@layer` statements, are removed. The names
+//     of the `@layer` blocks are removed, making them anonymous layers.
+//   - Functions with escaped names and remote urls in `image()` and
+//     `image-set()` strings are removed.
+//   - `:global` blocks and selectors are unwrapped, so their classes are scoped.
+//   - Selectors whose subject, the compound selector after the last combinator,
+//     has no local class are removed, as well as the rules left without
+//     selectors. This applies to the nested rules too.
+//
+// The output is balanced: the `}`, `)` and `]` closing nothing are removed, and
+// the blocks, parentheses, strings and comments left open are closed, so the
+// stylesheet can be concatenated with other CSS without affecting it. Every
+// removal is reported as a Diagnostic. The safe mode always processes the
+// stylesheet with ModeLocal and WithKeyframesScoping.
+type SafeMode struct {
+	// MaxInputSize is the maximum size of the stylesheet in bytes
+	MaxInputSize int
+	// MaxTokens is the maximum number of tokens of the stylesheet
+	MaxTokens int
+	// MaxDepth is the maximum nesting depth of blocks
+	MaxDepth int
+
+	// Report is called with every removal done to the stylesheet, it can be nil
+	Report func(Diagnostic)
+}
+
+// Diagnostic describes a removal done to a stylesheet processed in safe mode.
+type Diagnostic struct {
+	// Pos is the position of the removed CSS in the stylesheet
+	Pos Position
+	// Message describes why the CSS was removed
+	Message string
+	// Text is the removed CSS
+	Text string
+}
+
+func (d Diagnostic) String() string {
+	return d.Pos.String() + ": " + d.Message + ": " + d.Text
+}
+
+func (sm *SafeMode) maxInputSize() int {
+	if sm.MaxInputSize > 0 {
+		return sm.MaxInputSize
+	}
+	return DefaultMaxInputSize
+}
+
+func (sm *SafeMode) maxTokens() int {
+	if sm.MaxTokens > 0 {
+		return sm.MaxTokens
+	}
+	return DefaultMaxTokens
+}
+
+func (sm *SafeMode) maxDepth() int {
+	if sm.MaxDepth > 0 {
+		return sm.MaxDepth
+	}
+	return DefaultMaxDepth
+}
+
+// newInput reads the stylesheet, reading at most one byte more than the maximum
+// input size in safe mode.
+func newInput(r io.Reader, cfg *config) *parse.Input {
+	return parse.NewInput(limitInput(r, cfg))
+}
+
+// limitInput limits the stylesheet read from r to one byte more than the
+// maximum input size in safe mode.
+func limitInput(r io.Reader, cfg *config) io.Reader {
+	if cfg.safeMode != nil {
+		return io.LimitReader(r, int64(cfg.safeMode.maxInputSize())+1)
+	}
+	return r
+}
+
+// Kinds of the blocks found by the sanitizer and by the processor
+const (
+	// Block containing rules, like the stylesheet itself or `@media` blocks
+	blockRules = iota
+	// Block containing declarations, like the block of a style rule
+	blockDeclarations
+	// Block of a `@keyframes` rule
+	blockKeyframes
+	// Block of a `:global` block, its braces are removed
+	blockGlobal
+)
+
+type sanitizerToken struct {
+	tt     css_parser.TokenType
+	data   []byte
+	offset int
+}
+
+// cssSanitizer writes a stylesheet applying the restrictions of the safe mode.
+type cssSanitizer struct {
+	sm  *SafeMode
+	ctx context.Context
+	w   *bytes.Buffer
+	in  *parse.Input
+	zz  *css_parser.Lexer
+
+	tokens int
+	blocks []int
+
+	// prelude holds the tokens of the prelude of the rule being sanitized
+	prelude []sanitizerToken
+	// closers are the closing parentheses and brackets of the prelude or
+	// declaration being written
+	closers []byte
+
+	err error
+}
+
+// sanitizeCSS writes the stylesheet of in to w applying the restrictions of the
+// safe mode.
+func sanitizeCSS(ctx context.Context, sm *SafeMode, in *parse.Input, w *bytes.Buffer) error {
+	s := &cssSanitizer{
+		sm:     sm,
+		ctx:    ctx,
+		w:      w,
+		in:     in,
+		zz:     css_parser.NewLexer(in),
+		blocks: []int{blockRules},
+	}
+	if in.Len() > sm.maxInputSize() {
+		return s.limitError(0, "the stylesheet is bigger than %d bytes", sm.maxInputSize())
+	}
+	for {
+		tt, data, offset := s.next()
+		if tt == css_parser.ErrorToken {
+			if s.err != nil {
+				return s.err
+			}
+			if err := s.zz.Err(); err != io.EOF {
+				return err
+			}
+			// The blocks left open are closed, so the CSS following the
+			// stylesheet isn't part of them
+			for len(s.blocks) > 1 {
+				s.closeBlock()
+			}
+			return nil
+		}
+		switch kind := s.blocks[len(s.blocks)-1]; kind {
+		case blockRules, blockGlobal:
+			s.sanitizeRule(tt, data, offset)
+		default:
+			s.sanitizeDeclarations(kind, tt, data, offset)
+		}
+	}
+}
+
+// next returns the next token and its offset, or an ErrorToken setting s.err if
+// a limit is exceeded or the context is done.
+func (s *cssSanitizer) next() (css_parser.TokenType, []byte, int) {
+	if s.err != nil {
+		return css_parser.ErrorToken, nil, s.in.Offset()
+	}
+	offset := s.in.Offset()
+	if err := contextErr(s.ctx); err != nil {
+		s.err = &PositionError{Pos: positionOf(s.in.Bytes(), offset), Err: err}
+		return css_parser.ErrorToken, nil, offset
+	}
+	s.tokens++
+	if s.tokens > s.sm.maxTokens() {
+		s.err = s.limitError(offset, "the stylesheet has more than %d tokens", s.sm.maxTokens())
+		return css_parser.ErrorToken, nil, offset
+	}
+	tt, data := s.zz.Next()
+	return tt, data, offset
+}
+
+func (s *cssSanitizer) limitError(offset int, format string, a ...any) error {
+	return &PositionError{
+		Pos: positionOf(s.in.Bytes(), offset),
+		Err: fmt.Errorf("%w: %s", ErrLimitExceeded, fmt.Sprintf(format, a...)),
+	}
+}
+
+func (s *cssSanitizer) report(offset int, text []byte, msg string) {
+	if s.sm.Report != nil {
+		s.sm.Report(Diagnostic{
+			Pos:     positionOf(s.in.Bytes(), offset),
+			Message: msg,
+			Text:    string(text),
+		})
+	}
+}
+
+func (s *cssSanitizer) push(kind int, offset int) {
+	s.blocks = append(s.blocks, kind)
+	if len(s.blocks)-1 > s.sm.maxDepth() && s.err == nil {
+		s.err = s.limitError(offset, "the stylesheet has more than %d nested blocks", s.sm.maxDepth())
+	}
+}
+
+// closeBlock closes the current block, writing its closing brace unless it's a
+// `:global` block. The `}` closing no block, the last token read, is removed.
+func (s *cssSanitizer) closeBlock() {
+	if len(s.blocks) == 1 {
+		s.report(s.in.Offset()-1, []byte("}"), "removed unmatched }")
+		return
+	}
+	kind := s.blocks[len(s.blocks)-1]
+	s.blocks = s.blocks[:len(s.blocks)-1]
+	if kind != blockGlobal {
+		s.w.WriteByte('}')
+	}
+}
+
+// sanitizeRule sanitizes a rule starting at the token given, found in a block
+// of rules.
+func (s *cssSanitizer) sanitizeRule(tt css_parser.TokenType, data []byte, offset int) {
+	switch tt {
+	case css_parser.WhitespaceToken, css_parser.CommentToken, css_parser.CDOToken, css_parser.CDCToken, css_parser.SemicolonToken:
+		s.writeToken(tt, data)
+	case css_parser.RightBraceToken:
+		s.closeBlock()
+	case css_parser.AtKeywordToken:
+		s.sanitizeAtRule(data, offset, false)
+	default:
+		s.sanitizeStyleRule(tt, data, offset)
+	}
+}
+
+// readPrelude reads the prelude of a rule until a `{` or `;` token, returning
+// the token ending it.
+func (s *cssSanitizer) readPrelude(tt css_parser.TokenType, data []byte, offset int) css_parser.TokenType {
+	s.prelude = s.prelude[:0]
+	for {
+		switch tt {
+		case css_parser.ErrorToken, css_parser.LeftBraceToken, css_parser.SemicolonToken, css_parser.RightBraceToken:
+			return tt
+		}
+		s.prelude = append(s.prelude, sanitizerToken{tt: tt, data: data, offset: offset})
+		tt, data, offset = s.next()
+	}
+}
+
+// skipBlock skips the tokens until the end of the block that was just opened.
+func (s *cssSanitizer) skipBlock() {
+	braceCount := 1
+	for braceCount > 0 {
+		tt, _, _ := s.next()
+		switch tt {
+		case css_parser.ErrorToken:
+			return
+		case css_parser.LeftBraceToken:
+			braceCount++
+		case css_parser.RightBraceToken:
+			braceCount--
+		}
+	}
+}
+
+// sanitizeAtRule sanitizes an at-rule, nested is set for the at-rules found in
+// a block of declarations, whose blocks contain declarations too.
+func (s *cssSanitizer) sanitizeAtRule(keyword []byte, offset int, nested bool) {
+	tt, data, dataOffset := s.next()
+	end := s.readPrelude(tt, data, dataOffset)
+
+	name := string(bytes.ToLower(keyword))
+	if isRemovedAtRule(name) || bytes.IndexByte(keyword, '\\') != -1 {
+		if end == css_parser.LeftBraceToken {
+			s.skipBlock()
+		}
+		s.report(offset, s.in.Bytes()[offset:s.in.Offset()], "removed "+name+" rule")
+		if end == css_parser.RightBraceToken {
+			s.closeBlock()
+		}
+		return
+	}
+	if name == "@layer" {
+		// The layers are shared with the page, only anonymous layers are kept
+		if end != css_parser.LeftBraceToken {
+			s.report(offset, s.in.Bytes()[offset:s.in.Offset()], "removed @layer rule")
+			if end == css_parser.RightBraceToken {
+				s.closeBlock()
+			}
+			return
+		}
+		if !isWhitespace(s.prelude) {
+			s.report(offset, bytes.TrimSpace(s.in.Bytes()[offset:s.in.Offset()-1]), "removed @layer name")
+			s.prelude = s.prelude[:0]
+		}
+	}
+
+	s.w.Write(keyword)
+	s.writePrelude(s.prelude)
+	if end == css_parser.RightBraceToken {
+		s.closeBlock()
+		return
+	}
+	if end != css_parser.LeftBraceToken {
+		if end == css_parser.SemicolonToken {
+			s.w.WriteByte(';')
+		}
+		return
+	}
+	s.w.WriteByte('{')
+	switch name {
+	case "@media", "@supports", "@container", "@layer", "@document", "@scope", "@starting-style":
+		if nested {
+			s.push(blockDeclarations, dataOffset)
+		} else {
+			s.push(blockRules, dataOffset)
+		}
+	case "@keyframes", "@-webkit-keyframes", "@-moz-keyframes", "@-o-keyframes":
+		s.push(blockKeyframes, dataOffset)
+	default:
+		s.push(blockDeclarations, dataOffset)
+	}
+}
+
+func (s *cssSanitizer) sanitizeStyleRule(tt css_parser.TokenType, data []byte, offset int) {
+	end := s.readPrelude(tt, data, offset)
+	prelude := s.unwrapGlobal(s.prelude)
+
+	if end != css_parser.LeftBraceToken {
+		// Invalid CSS, it's written as is without its prelude
+		if len(prelude) != 0 {
+			s.report(offset, s.in.Bytes()[offset:s.in.Offset()], "removed invalid rule")
+		}
+		if end == css_parser.RightBraceToken {
+			s.closeBlock()
+		}
+		return
+	}
+
+	if isWhitespace(prelude) {
+		// `:global {` block, its braces are removed as the processor does
+		s.writePrelude(prelude)
+		s.push(blockGlobal, offset)
+		return
+	}
+
+	if !s.writeSelectors(prelude, false) {
+		s.skipBlock()
+		return
+	}
+	s.w.WriteByte('{')
+	s.push(blockDeclarations, offset)
+}
+
+// writeSelectors writes the selectors of the prelude whose subject has a local
+// class, reporting the other ones. It reports if any selector was written.
+func (s *cssSanitizer) writeSelectors(prelude []sanitizerToken, nested bool) bool {
+	written := false
+	start := 0
+	parenCount := 0
+	for i := 0; i <= len(prelude); i++ {
+		if i != len(prelude) {
+			switch prelude[i].tt {
+			case css_parser.FunctionToken, css_parser.LeftParenthesisToken:
+				parenCount++
+			case css_parser.RightParenthesisToken:
+				parenCount--
+			}
+			if prelude[i].tt != css_parser.CommaToken || parenCount != 0 {
+				continue
+			}
+		}
+		selector := prelude[start:i]
+		start = i + 1
+		if hasLocalSubject(selector, nested) {
+			if written {
+				s.w.WriteByte(',')
+			}
+			s.writePrelude(selector)
+			written = true
+			continue
+		}
+		if len(selector) != 0 {
+			text := s.tokensText(selector, 0, len(selector)-1)
+			s.report(selector[0].offset, bytes.TrimSpace(text), "removed selector without local classes")
+		}
+	}
+	return written
+}
+
+// unwrapGlobal removes the `:global` and `:global(...)` pseudo-classes of the
+// prelude, reporting them.
+func (s *cssSanitizer) unwrapGlobal(prelude []sanitizerToken) []sanitizerToken {
+	unwrapped := prelude[:0]
+	parenCount := 0
+	// Depth of the `:global(` functions being unwrapped
+	var globalDepths []int
+	for i := 0; i < len(prelude); i++ {
+		t := prelude[i]
+		if t.tt == css_parser.ColonToken && i+1 < len(prelude) {
+			next := prelude[i+1]
+			if next.tt == css_parser.IdentToken && string(next.data) == "global" {
+				s.report(t.offset, []byte(":global"), "removed :global")
+				i++
+				unwrapped = appendBoundary(unwrapped, prelude, i, t.offset)
+				continue
+			}
+			if next.tt == css_parser.FunctionToken && string(next.data) == "global(" {
+				s.report(t.offset, []byte(":global("), "removed :global")
+				parenCount++
+				globalDepths = append(globalDepths, parenCount)
+				i++
+				unwrapped = appendBoundary(unwrapped, prelude, i, t.offset)
+				continue
+			}
+		}
+		switch t.tt {
+		case css_parser.FunctionToken, css_parser.LeftParenthesisToken:
+			parenCount++
+		case css_parser.RightParenthesisToken:
+			if len(globalDepths) != 0 && globalDepths[len(globalDepths)-1] == parenCount {
+				globalDepths = globalDepths[:len(globalDepths)-1]
+				parenCount--
+				unwrapped = appendBoundary(unwrapped, prelude, i, t.offset)
+				continue
+			}
+			parenCount--
+		}
+		unwrapped = append(unwrapped, t)
+	}
+	return unwrapped
+}
+
+// boundary is the comment written in place of a removed token, so the tokens
+// around it aren't joined, `.a:global(div)` becoming `.a/**/div` rather than
+// the class `adiv`.
+var boundary = []byte("/**/")
+
+// appendBoundary appends a boundary to the unwrapped prelude if the token
+// removed, prelude[i], is between two tokens that would be joined otherwise.
+func appendBoundary(unwrapped, prelude []sanitizerToken, i int, offset int) []sanitizerToken {
+	if len(unwrapped) == 0 || i+1 >= len(prelude) {
+		return unwrapped
+	}
+	switch unwrapped[len(unwrapped)-1].tt {
+	case css_parser.WhitespaceToken, css_parser.CommentToken, css_parser.CommaToken:
+		return unwrapped
+	}
+	switch prelude[i+1].tt {
+	case css_parser.WhitespaceToken, css_parser.CommentToken, css_parser.CommaToken, css_parser.RightParenthesisToken:
+		return unwrapped
+	}
+	return append(unwrapped, sanitizerToken{tt: css_parser.CommentToken, data: boundary, offset: offset})
+}
+
+func (s *cssSanitizer) writePrelude(prelude []sanitizerToken) {
+	s.closers = s.closers[:0]
+	for _, t := range prelude {
+		if t.tt == css_parser.URLToken || t.tt == css_parser.BadURLToken {
+			s.sanitizeURL(t.tt, t.data, t.offset)
+			continue
+		}
+		s.writeNested(t.tt, t.data)
+	}
+	s.writeClosers()
+}
+
+// sanitizeDeclarations sanitizes a declaration or a nested rule starting at the
+// token given, found in a block of declarations or of keyframes. The nested
+// style rules are sanitized like the rules of the stylesheet, the `&` selector
+// standing for a local class.
+func (s *cssSanitizer) sanitizeDeclarations(kind int, tt css_parser.TokenType, data []byte, offset int) {
+	switch tt {
+	case css_parser.WhitespaceToken, css_parser.CommentToken, css_parser.SemicolonToken:
+		s.writeToken(tt, data)
+		return
+	case css_parser.RightBraceToken:
+		s.closeBlock()
+		return
+	case css_parser.AtKeywordToken:
+		if kind == blockDeclarations {
+			s.sanitizeAtRule(data, offset, true)
+			return
+		}
+	}
+
+	end := s.readPrelude(tt, data, offset)
+	switch end {
+	case css_parser.LeftBraceToken:
+		if kind == blockKeyframes {
+			s.writePrelude(s.prelude)
+		} else if !s.writeSelectors(s.unwrapGlobal(s.prelude), true) {
+			s.skipBlock()
+			return
+		}
+		s.w.WriteByte('{')
+		s.push(blockDeclarations, offset)
+	case css_parser.SemicolonToken:
+		s.writeDeclaration(s.prelude)
+		s.w.WriteByte(';')
+	case css_parser.RightBraceToken:
+		s.writeDeclaration(s.prelude)
+		s.closeBlock()
+	default:
+		s.writeDeclaration(s.prelude)
+	}
+}
+
+// writeDeclaration writes the tokens of a declaration, removing the remote urls
+// of its `url()`, `src()`, `image()` and `image-set()` values and the functions
+// with escaped names, which could be any of them.
+func (s *cssSanitizer) writeDeclaration(decl []sanitizerToken) {
+	s.closers = s.closers[:0]
+	// imageDepth is the depth of the image() or image-set() the strings are
+	// in, whose strings are urls too, or -1
+	imageDepth := -1
+	for i := 0; i < len(decl); i++ {
+		t := decl[i]
+		switch t.tt {
+		case css_parser.URLToken, css_parser.BadURLToken:
+			s.sanitizeURL(t.tt, t.data, t.offset)
+			continue
+		case css_parser.StringToken:
+			if imageDepth != -1 && isRemoteURL(unquote(t.data)) {
+				s.report(t.offset, t.data, "removed remote url")
+				continue
+			}
+		case css_parser.FunctionToken:
+			name := bytes.ToLower(t.data)
+			if bytes.IndexByte(name, '\\') != -1 {
+				j := matchingParen(decl, i)
+				s.report(t.offset, s.tokensText(decl, i, j), "removed escaped function")
+				i = j
+				continue
+			}
+			if string(name) == "url(" || string(name) == "src(" {
+				// url( followed by a string
+				j := i + 1
+				for j < len(decl) && decl[j].tt == css_parser.WhitespaceToken {
+					j++
+				}
+				if j >= len(decl) || decl[j].tt != css_parser.StringToken || isRemoteURL(unquote(decl[j].data)) {
+					j = matchingParen(decl, i)
+					s.report(t.offset, s.tokensText(decl, i, j), "removed remote url")
+					i = j
+					continue
+				}
+			}
+			if imageDepth == -1 && (bytes.HasSuffix(name, []byte("image(")) || bytes.HasSuffix(name, []byte("image-set("))) {
+				imageDepth = len(s.closers)
+			}
+		}
+		s.writeNested(t.tt, t.data)
+		if len(s.closers) <= imageDepth {
+			imageDepth = -1
+		}
+	}
+	s.writeClosers()
+}
+
+// matchingParen returns the index of the `)` closing the function at tokens[i],
+// or the index of the last token if it isn't closed.
+func matchingParen(tokens []sanitizerToken, i int) int {
+	depth := 0
+	for j := i; j < len(tokens); j++ {
+		switch tokens[j].tt {
+		case css_parser.FunctionToken, css_parser.LeftParenthesisToken:
+			depth++
+		case css_parser.RightParenthesisToken:
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return len(tokens) - 1
+}
+
+// tokensText returns the CSS of the tokens from i to j, both included.
+func (s *cssSanitizer) tokensText(tokens []sanitizerToken, i, j int) []byte {
+	return s.in.Bytes()[tokens[i].offset : tokens[j].offset+len(tokens[j].data)]
+}
+
+// writeNested writes a token of a prelude or a declaration, keeping track of
+// the parentheses and brackets open. A `)` or `]` closing nothing is removed.
+func (s *cssSanitizer) writeNested(tt css_parser.TokenType, data []byte) {
+	switch tt {
+	case css_parser.FunctionToken, css_parser.LeftParenthesisToken:
+		s.closers = append(s.closers, ')')
+	case css_parser.LeftBracketToken:
+		s.closers = append(s.closers, ']')
+	case css_parser.RightParenthesisToken, css_parser.RightBracketToken:
+		if len(s.closers) == 0 {
+			return
+		}
+		if s.closers[len(s.closers)-1] == data[0] {
+			s.closers = s.closers[:len(s.closers)-1]
+		}
+	}
+	s.writeToken(tt, data)
+}
+
+// writeClosers closes the parentheses and brackets left open by the prelude or
+// declaration written, which would contain the CSS following them otherwise.
+func (s *cssSanitizer) writeClosers() {
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		s.w.WriteByte(s.closers[i])
+	}
+	s.closers = s.closers[:0]
+}
+
+// writeToken writes a token, closing the comments, strings and urls left open
+// at the end of the input, so the CSS following the stylesheet isn't part of
+// them.
+func (s *cssSanitizer) writeToken(tt css_parser.TokenType, data []byte) {
+	s.w.Write(data)
+	switch tt {
+	case css_parser.CommentToken:
+		if len(data) < len("/**/") || !bytes.HasSuffix(data, []byte("*/")) {
+			s.w.WriteString("*/")
+		}
+	case css_parser.StringToken:
+		if len(data) < 2 || data[len(data)-1] != data[0] || isEscaped(data, len(data)-1) {
+			if isEscaped(data, len(data)) {
+				s.w.WriteByte('\\')
+			}
+			s.w.WriteByte(data[0])
+		}
+	case css_parser.URLToken, css_parser.BadURLToken:
+		if data[len(data)-1] != ')' || isEscaped(data, len(data)-1) {
+			if isEscaped(data, len(data)) {
+				s.w.WriteByte('\\')
+			}
+			s.w.WriteByte(')')
+		}
+	}
+}
+
+// isEscaped reports if the byte at i is preceded by an odd number of
+// backslashes.
+func isEscaped(b []byte, i int) bool {
+	n := 0
+	for i-n > 0 && b[i-n-1] == '\\' {
+		n++
+	}
+	return n%2 == 1
+}
+
+// sanitizeURL writes an url token if it isn't a remote url, reporting it
+// otherwise. The url token may be missing its `)` at the end of the input.
+func (s *cssSanitizer) sanitizeURL(tt css_parser.TokenType, data []byte, offset int) {
+	// Remove `url(` and `)`
+	u := data[min(len("url("), len(data)):]
+	u = bytes.TrimSuffix(u, []byte(")"))
+	u = bytes.TrimSpace(u)
+	if len(u) != 0 && (u[0] == '"' || u[0] == '\'') {
+		u = unquote(u)
+	}
+	if isRemoteURL(u) {
+		s.report(offset, data, "removed remote url")
+		return
+	}
+	s.writeToken(tt, data)
+}
+
+// unquote removes the quotes of a string token, which may be missing its
+// closing quote at the end of the input.
+func unquote(str []byte) []byte {
+	if len(str) == 0 {
+		return str
+	}
+	if len(str) >= 2 && str[len(str)-1] == str[0] {
+		return str[1 : len(str)-1]
+	}
+	return str[1:]
+}
+
+// removedAtRules are the at-rules removed in safe mode, they define names
+// shared with the rest of the page that can't be scoped
+var removedAtRules = []string{
+	"@import", "@font-face", "@property", "@counter-style", "@font-feature-values", "@font-palette-values",
+}
+
+func isRemovedAtRule(name string) bool {
+	for _, r := range removedAtRules {
+		if name == r {
+			return true
+		}
+	}
+	return false
+}
+
+// isRemoteURL reports if the url can be fetched from another origin. Escaped
+// urls are considered remote.
+func isRemoteURL(u []byte) bool {
+	u = bytes.TrimSpace(u)
+	if bytes.IndexByte(u, '\\') != -1 || bytes.HasPrefix(u, []byte("//")) {
+		return true
+	}
+	for i, c := range u {
+		if c == ':' {
+			return !bytes.EqualFold(u[:i], []byte("data"))
+		}
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i != 0 && ('0' <= c && c <= '9' || c == '+' || c == '-' || c == '.')) {
+			return false
+		}
+	}
+	return false
+}
+
+// hasLocalSubject reports if the subject of the selector, the compound
+// selector following its last combinator, has a class outside of functional
+// pseudo-classes other than `:local(`. In nested rules the `&` selector counts
+// as a local class, its parent rule having a local subject.
+func hasLocalSubject(selector []sanitizerToken, nested bool) bool {
+	// parens are the parentheses open, nonLocal the ones not opened by `local(`
+	var parens []bool
+	nonLocal := 0
+	local, space := false, false
+	for i, t := range selector {
+		switch t.tt {
+		case css_parser.CommentToken:
+			continue
+		case css_parser.WhitespaceToken:
+			space = nonLocal == 0
+			continue
+		case css_parser.RightParenthesisToken:
+			if len(parens) != 0 {
+				if !parens[len(parens)-1] {
+					nonLocal--
+				}
+				parens = parens[:len(parens)-1]
+			}
+			space = false
+			continue
+		}
+		if nonLocal == 0 {
+			if t.tt == css_parser.DelimToken && (string(t.data) == ">" || string(t.data) == "+" || string(t.data) == "~") {
+				local, space = false, false
+				continue
+			}
+			if space {
+				// Descendant combinator
+				local, space = false, false
+			}
+			if t.tt == css_parser.DelimToken && string(t.data) == "." && i+1 < len(selector) && selector[i+1].tt == css_parser.IdentToken {
+				local = true
+			} else if nested && t.tt == css_parser.DelimToken && string(t.data) == "&" {
+				local = true
+			}
+		}
+		switch t.tt {
+		case css_parser.FunctionToken:
+			isLocal := string(t.data) == "local("
+			parens = append(parens, isLocal)
+			if !isLocal {
+				nonLocal++
+			}
+		case css_parser.LeftParenthesisToken:
+			parens = append(parens, false)
+			nonLocal++
+		}
+	}
+	return local
+}
+
+func isWhitespace(tokens []sanitizerToken) bool {
+	for _, t := range tokens {
+		if t.tt != css_parser.WhitespaceToken && t.tt != css_parser.CommentToken {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,231 @@
+package cssmodules
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+var testCasesSafeMode = []struct {
+	name                string
+	payload             string
+	safeMode            SafeMode
+	expectedCSSModules  string
+	expectedDiagnostics []string
+	expectedError       error
+}{
+	{
+		name:                "SafeMode_LocalRule",
+		expectedCSSModules:  `.${a}:hover, .${b} > span.${c}{color:red; background: url(img.png)}`,
+		expectedDiagnostics: nil,
+
+		payload: `.a:hover, .b > span.c{color:red; background: url(img.png)}`,
+	},
+	{
+		name:               "SafeMode_Import",
+		expectedCSSModules: "\n.${a}{color:red}",
+		expectedDiagnostics: []string{
+			`1:1: removed @import rule: @import url("https://example.com/theme.css");`,
+		},
+
+		payload: "@import url(\"https://example.com/theme.css\");\n.a{color:red}",
+	},
+	{
+		name:               "SafeMode_RemoteURLs",
+		expectedCSSModules: `.${a}{background: ; mask: url("mask.svg"); content: image-set( 1x)}`,
+		expectedDiagnostics: []string{
+			`1:16: removed remote url: url(http://example.com/track.png)`,
+			`1:93: removed remote url: "//example.com/1.png"`,
+		},
+
+		payload: `.a{background: url(http://example.com/track.png); mask: url("mask.svg"); content: image-set("//example.com/1.png" 1x)}`,
+	},
+	{
+		name:               "SafeMode_Global",
+		expectedCSSModules: `.${a} .${b}{color:red}  .${c}{color:blue} `,
+		expectedDiagnostics: []string{
+			`1:1: removed :global: :global(`,
+			`1:26: removed :global: :global`,
+		},
+
+		payload: `:global(.a) .b{color:red}:global { .c{color:blue} }`,
+	},
+	{
+		name:               "SafeMode_NonLocalSelectors",
+		expectedCSSModules: `.${a}{color:red} @media screen{.${c}{color:blue}}`,
+		expectedDiagnostics: []string{
+			`1:1: removed selector without local classes: body`,
+			`1:6: removed selector without local classes: div`,
+			`1:39: removed selector without local classes: #b`,
+			`1:68: removed selector without local classes: span:not(.d)`,
+		},
+
+		payload: `body, div,.a{color:red} @media screen{#b{color:blue}.c{color:blue}}span:not(.d){color:green}`,
+	},
+	{
+		name:               "SafeMode_NonLocalSubjects",
+		expectedCSSModules: `.${a} .${b}:not(.${c}){color:red}`,
+		expectedDiagnostics: []string{
+			`1:1: removed selector without local classes: :local(div) :not(.x)`,
+			`1:32: removed selector without local classes: .x ~ div`,
+			`1:54: removed selector without local classes: .x + *`,
+		},
+
+		payload: `:local(div) :not(.x){color:red}.x ~ div{display:none}.x + *{color:red}.a .b:not(.c){color:red}`,
+	},
+	{
+		name:               "SafeMode_NestedRules",
+		expectedCSSModules: `.${a}{  &:hover{color:blue} .${b}{color:red} @media screen{color:red} }`,
+		expectedDiagnostics: []string{
+			`1:5: removed selector without local classes: & ~ div`,
+		},
+
+		payload: `.a{ & ~ div{color:red} &:hover{color:blue} .b{color:red} @media screen{color:red} }`,
+	},
+	{
+		name:               "SafeMode_Keyframes",
+		expectedCSSModules: `.${a}{animation:${@fade} 1s}@keyframes ${@fade}{from{opacity:0}to{opacity:1}}`,
+
+		payload: `.a{animation:fade 1s}@keyframes fade{from{opacity:0}to{opacity:1}}`,
+	},
+	{
+		name:               "SafeMode_GlobalNames",
+		expectedCSSModules: `.${a}{font-family:"Inter"}`,
+		expectedDiagnostics: []string{
+			`1:1: removed @font-face rule: @font-face{font-family:"Inter";src:url(/u/evil.woff)}`,
+			`1:54: removed @property rule: @property --x{syntax:"*"}`,
+			`1:79: removed @counter-style rule: @counter-style x{system:cyclic}`,
+		},
+
+		payload: `@font-face{font-family:"Inter";src:url(/u/evil.woff)}@property --x{syntax:"*"}@counter-style x{system:cyclic}.a{font-family:"Inter"}`,
+	},
+	{
+		name:               "SafeMode_TruncatedURL",
+		expectedCSSModules: `.${a}{background:url()}`,
+
+		payload: `.a{background:url(`,
+	},
+	{
+		name:               "SafeMode_TruncatedImageSet",
+		expectedCSSModules: `.${a}{background:image-set("")}`,
+
+		payload: `.a{background:image-set("`,
+	},
+	{
+		name:               "SafeMode_TruncatedSrc",
+		expectedCSSModules: `.${a}{background:src("")}`,
+
+		payload: `.a{background:src("`,
+	},
+	{
+		name:          "SafeMode_MaxInputSize",
+		safeMode:      SafeMode{MaxInputSize: 10},
+		expectedError: ErrLimitExceeded,
+
+		payload: `.a{color:red}`,
+	},
+	{
+		name:          "SafeMode_MaxTokens",
+		safeMode:      SafeMode{MaxTokens: 5},
+		expectedError: ErrLimitExceeded,
+
+		payload: `.a{color:red}`,
+	},
+	{
+		name:          "SafeMode_MaxDepth",
+		safeMode:      SafeMode{MaxDepth: 2},
+		expectedError: ErrLimitExceeded,
+
+		payload: `@media screen{@supports (display:grid){.a{color:red}}}`,
+	},
+	{
+		name:               "SafeMode_EscapedFunctions",
+		expectedCSSModules: `.${a}{background:; mask:; content:image( 1x)}`,
+		expectedDiagnostics: []string{
+			`1:15: removed escaped function: u\72l(http://evil/x.png)`,
+			`1:46: removed escaped function: u\72l("http://evil")`,
+			`1:82: removed remote url: "http://evil/1.png"`,
+		},
+
+		payload: `.a{background:u\72l(http://evil/x.png); mask:u\72l("http://evil"); content:image("http://evil/1.png" 1x)}`,
+	},
+	{
+		name:               "SafeMode_UnmatchedBraces",
+		expectedCSSModules: `.${a}{}  .${b}{color:red}`,
+		expectedDiagnostics: []string{
+			`1:6: removed unmatched }: }`,
+			`1:8: removed selector without local classes: body`,
+		},
+
+		payload: `.a{} } body{}.b{color:red}`,
+	},
+	{
+		name:               "SafeMode_OpenBlocks",
+		expectedCSSModules: `@media screen{.${a}{color:"red"}}`,
+
+		payload: `@media screen{.a{color:"red`,
+	},
+	{
+		name:               "SafeMode_OpenComment",
+		expectedCSSModules: `.${a}{color:red}/* comment*/`,
+
+		payload: `.a{color:red}/* comment`,
+	},
+	{
+		name:               "SafeMode_OpenParentheses",
+		expectedCSSModules: `.${a}{background:; color:rgb(0 0 0)}`,
+		expectedDiagnostics: []string{
+			`1:15: removed remote url: url(javascript:alert(1)`,
+		},
+
+		payload: `.a{background:url(javascript:alert(1)); color:rgb(0 0 0`,
+	},
+	{
+		name:               "SafeMode_Layers",
+		expectedCSSModules: `@layer{.${a}{color:red}}`,
+		expectedDiagnostics: []string{
+			`1:1: removed @layer rule: @layer app, tenant;`,
+			`1:20: removed @layer name: @layer app`,
+		},
+
+		payload: `@layer app, tenant;@layer app{.a{color:red}}`,
+	},
+	{
+		name:               "SafeMode_GlobalBoundary",
+		expectedCSSModules: `.${a}/**/div{color:red}`,
+		expectedDiagnostics: []string{
+			`1:3: removed :global: :global(`,
+		},
+
+		payload: `.a:global(div){color:red}`,
+	},
+}
+
+func TestProcessCSSModules_SafeMode(t *testing.T) {
+	for i := range testCasesSafeMode {
+		tc := testCasesSafeMode[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var diagnostics []string
+			tc.safeMode.Report = func(d Diagnostic) {
+				diagnostics = append(diagnostics, d.String())
+			}
+			css, scopedClasses, err := ProcessCSSModules(strings.NewReader(tc.payload), WithSafeMode(tc.safeMode))
+			if !errors.Is(err, tc.expectedError) {
+				t.Errorf("unexpected error value: expected %v got %v", tc.expectedError, err)
+				return
+			}
+			if err != nil {
+				return
+			}
+			expected := os.Expand(tc.expectedCSSModules, func(s string) string { return scopedClasses[s] })
+			if string(css) != expected {
+				t.Errorf("unexpected css value: expected\n%q\ngot\n%q", expected, css)
+			}
+			if strings.Join(diagnostics, "\n") != strings.Join(tc.expectedDiagnostics, "\n") {
+				t.Errorf("unexpected diagnostics value: expected\n%s\ngot\n%s", strings.Join(tc.expectedDiagnostics, "\n"), strings.Join(diagnostics, "\n"))
+			}
+		})
+	}
+}
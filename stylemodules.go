@@ -0,0 +1,182 @@
+package cssmodules
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/tdewolff/parse/v2"
+	html_parser "golang.org/x/net/html"
+)
+
+// WithStyleModules makes the HTML processor scope the CSS of the
+// `<style module>` elements of the document in place. The classes of these
+// elements take precedence over the scoped classes passed to the processor,
+// and the classes of the named ones, like `<style module="card">`, are
+// resolved as `card:title` or inside of a `css-module-scope="card"` element.
+// The `module` attribute is removed.
+//
+// The document is read as a whole before processing it, because the classes
+// can be used before the `<style>` element that defines them.
+func WithStyleModules() Option {
+	return func(c *config) {
+		c.styleModules = true
+	}
+}
+
+var (
+	styleTag   = []byte("style")
+	moduleAttr = []byte("module")
+)
+
+// styleModuleAttr returns the index of the `module` attribute in attrs if the
+// tag is a `<style>` element, -1 otherwise.
+func styleModuleAttr(raw []byte, nameEnd int, attrs []htmlAttr) int {
+	if !bytes.EqualFold(raw[1:nameEnd], styleTag) {
+		return -1
+	}
+	for i := range attrs {
+		if bytes.EqualFold(attrs[i].key(raw), moduleAttr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// scanModules processes the CSS of the `<style module>` elements of the
+// document, keeping it in p.styles, and the modules of its `<link>` elements,
+// keeping their paths in p.links. It returns the configuration resolving their
+// classes, or nil if the document doesn't have any.
+func (p *htmlProcessor) scanModules(ctx context.Context, doc []byte, scopedClasses map[string]string) (*config, error) {
+	p.styleBuf = p.styleBuf[:0]
+	p.styles = p.styles[:0]
+	p.style = 0
+	p.links = p.links[:0]
+	p.link = 0
+
+	// modules are the classes of the elements by the value of their `module`
+	// attribute, the elements with the same name are merged
+	var modules map[string]ClassMap
+	addModule := func(alias string, classes map[string]string) {
+		if modules == nil {
+			modules = map[string]ClassMap{}
+		}
+		if modules[alias] == nil {
+			modules[alias] = ClassMap{}
+		}
+		for k, v := range classes {
+			modules[alias][k] = v
+		}
+	}
+	var proc *cssProcessor
+	zz := html_parser.NewTokenizer(bytes.NewReader(doc))
+	for {
+		zt := zz.Next()
+		if zt == html_parser.ErrorToken {
+			break
+		}
+		if zt != html_parser.StartTagToken && zt != html_parser.SelfClosingTagToken {
+			continue
+		}
+		raw := zz.Raw()
+		nameEnd, attrs := scanTag(raw, p.attrs[:0], &p.cfg.delims)
+		p.attrs = attrs
+
+		if p.cfg.linkFS != nil {
+			if href, module := linkModuleAttrs(raw, nameEnd, attrs); href != -1 {
+				asset, err := p.cfg.manifest.load(ctx, p.cfg, linkModulePath(attrs[href].val(raw)))
+				if err != nil {
+					return nil, err
+				}
+				p.links = append(p.links, asset.Path)
+				alias := ""
+				if module != -1 {
+					alias = string(attrs[module].val(raw))
+				}
+				addModule(alias, asset.Classes)
+				continue
+			}
+		}
+
+		if !p.cfg.styleModules || zt != html_parser.StartTagToken {
+			continue
+		}
+		i := styleModuleAttr(raw, nameEnd, attrs)
+		if i == -1 {
+			continue
+		}
+		alias := string(attrs[i].val(raw))
+
+		var css []byte
+		if zz.Next() == html_parser.TextToken {
+			// The lexer writes past the end of the input if it has capacity
+			css = zz.Raw()
+			css = css[:len(css):len(css)]
+		}
+		if proc == nil {
+			proc = newCSSProcessor(p.cfg)
+		}
+		out := getBuffer()
+		classes, err := proc.process(ctx, parse.NewInputBytes(css), out)
+		p.styleBuf = append(p.styleBuf, out.Bytes()...)
+		releaseBuffer(out)
+		if err != nil {
+			return nil, err
+		}
+		p.styles = append(p.styles, len(p.styleBuf))
+		addModule(alias, classes)
+	}
+	if err := zz.Err(); err != io.EOF {
+		return nil, err
+	}
+	if modules == nil {
+		return nil, nil
+	}
+
+	cfg := *p.cfg
+	cfg.modules = Modules{}
+	for alias, module := range p.cfg.modules {
+		cfg.modules[alias] = module
+	}
+	for alias, module := range modules {
+		if alias == "" {
+			cfg.resolver = ChainResolver{module, p.cfg.resolverFor(scopedClasses)}
+		} else {
+			cfg.modules[alias] = module
+		}
+	}
+	return &cfg, nil
+}
+
+// processStyleModule writes a `<style module>` start tag without its `module`
+// attribute followed by its scoped CSS, reporting if the tag is one. The other
+// attributes of the tag are processed like the ones of any other tag.
+func (p *htmlProcessor) processStyleModule(raw []byte) (bool, error) {
+	nameEnd, attrs := scanTag(raw, p.attrs[:0], &p.cfg.delims)
+	p.attrs = attrs
+	i := styleModuleAttr(raw, nameEnd, attrs)
+	if i == -1 || p.style >= len(p.styles) {
+		return false, nil
+	}
+	// The attribute is removed along with the whitespace before it
+	prev := nameEnd
+	if i != 0 {
+		prev = attrs[i-1].end
+	}
+	p.tag = append(append(p.tag[:0], raw[:prev]...), raw[attrs[i].end:]...)
+	if err := p.processTag(p.tag, false); err != nil {
+		return false, err
+	}
+	// The text of the element is replaced by the scoped CSS, which isn't
+	// rewritten again
+	p.rewriteStyleText = false
+
+	start := 0
+	if p.style != 0 {
+		start = p.styles[p.style-1]
+	}
+	p.w.Write(p.styleBuf[start:p.styles[p.style]])
+	p.style++
+	p.skipStyleText = true
+	return true, nil
+}
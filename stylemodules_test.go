@@ -0,0 +1,59 @@
+package cssmodules
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestProcessHTMLWithCSSModules_StyleModules(t *testing.T) {
+	payload := `<h1 css-module="title">Hi</h1>` +
+		`<style module>.title{color:red}</style>` +
+		`<style type="text/css" module="card">.root{margin:0} .title{margin:1px}</style>` +
+		`<div css-module="card:root test-1"><p css-module-scope="card" css-module="title"></p></div>` +
+		`<style>.title{color:blue}</style>`
+	resultingHTML, err := ProcessHTMLWithCSSModules(strings.NewReader(payload), map[string]string{"test-1": "RAN_1"}, WithStyleModules())
+	if err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+
+	// The scoped classes are taken from the CSS, the first title is the one of
+	// the unnamed module
+	scopedClasses := map[string]string{}
+	for _, m := range regexp.MustCompile(`\.(_([a-z]+)_[\w-]{6})`).FindAllStringSubmatch(string(resultingHTML), -1) {
+		if _, ok := scopedClasses[m[2]]; ok {
+			m[2] = "card-" + m[2]
+		}
+		scopedClasses[m[2]] = m[1]
+	}
+	expectedHTML := os.Expand(`<h1 class="${title}">Hi</h1>`+
+		`<style>.${title}{color:red}</style>`+
+		`<style type="text/css">.${root}{margin:0} .${card-title}{margin:1px}</style>`+
+		`<div class="${root} RAN_1"><p class="${card-title}"></p></div>`+
+		`<style>.title{color:blue}</style>`, func(s string) string { return scopedClasses[s] })
+	if len(scopedClasses) != 3 || string(resultingHTML) != expectedHTML {
+		t.Errorf("unexpected html value: expected\n%s\ngot\n%s", expectedHTML, resultingHTML)
+	}
+}
+
+func TestProcessHTMLWithCSSModules_StyleModulesDisabled(t *testing.T) {
+	payload := `<style module>.title{color:red}</style><h1 css-module="title">Hi</h1>`
+	if _, err := ProcessHTMLWithCSSModules(strings.NewReader(payload), nil); !errors.Is(err, ErrClassNotFound) {
+		t.Errorf("unexpected error value: expected %v got %v", ErrClassNotFound, err)
+	}
+}
+
+func TestProcessHTMLWithCSSModules_StyleModulesAttributes(t *testing.T) {
+	payload := `<style module css-module-style media="print">.title{color:red}</style>` +
+		`<style css-module-style>.title{color:blue}</style>`
+	resultingHTML, err := ProcessHTMLWithCSSModules(strings.NewReader(payload), nil, WithStyleModules(), WithStyleRewriting())
+	if err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	expectedHTML := regexp.MustCompile(`^<style media="print">\._title_[\w-]{6}\{color:red\}</style><style>\.title\{color:blue\}</style>$`)
+	if !expectedHTML.Match(resultingHTML) {
+		t.Errorf("unexpected html value: expected to match\n%s\ngot\n%s", expectedHTML, resultingHTML)
+	}
+}
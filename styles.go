@@ -0,0 +1,77 @@
+package cssmodules
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/tdewolff/parse/v2"
+	css_parser "github.com/tdewolff/parse/v2/css"
+	html_parser "golang.org/x/net/html"
+)
+
+// WithStyleRewriting makes the HTML processor rewrite the CSS of the elements
+// with a `css-module-style` attribute: the keyframes names used by the
+// animation declarations and the custom properties of their `style` attribute,
+// or of their content for `<style>` elements, are replaced by the scoped names
+// found in the scoped classes, see WithKeyframesScoping and
+// WithCustomPropertiesScoping. The names not found are left as they are. The
+// `css-module-style` attribute is removed.
+func WithStyleRewriting() Option {
+	return func(c *config) {
+		c.styleRewriting = true
+	}
+}
+
+var (
+	styleAttr       = []byte("style")
+	styleMarkerAttr = []byte("css-module-style")
+)
+
+// rewriteStyleAttr returns the value of a `style` attribute rewritten, or "" if
+// nothing is.
+func (p *htmlProcessor) rewriteStyleAttr(val []byte) string {
+	if bytes.IndexByte(val, '&') == -1 {
+		return p.rewriteCSS(val, blockDeclarations)
+	}
+	rewritten := p.rewriteCSS([]byte(html_parser.UnescapeString(string(val))), blockDeclarations)
+	if rewritten == "" {
+		return ""
+	}
+	return html_parser.EscapeString(rewritten)
+}
+
+// rewriteCSS returns css with its keyframes names and custom properties scoped,
+// or "" if none of them is. kind is blockDeclarations for the `style`
+// attributes and blockRules for the `<style>` elements.
+func (p *htmlProcessor) rewriteCSS(css []byte, kind int) string {
+	// The lexer writes past the end of the input if it has capacity
+	zz := css_parser.NewLexer(parse.NewInputBytes(css[:len(css):len(css)]))
+	p.css.reset(kind)
+	sb := strings.Builder{}
+	offset, written := 0, 0
+	for {
+		zt, data := zz.Next()
+		if zt == css_parser.ErrorToken {
+			break
+		}
+		role := p.css.token(zt, data)
+		scoped, ok := "", false
+		if zt == css_parser.CustomPropertyNameToken {
+			scoped, ok = p.lookupKey(data)
+		} else if role == roleAnimationName {
+			p.idBuf = append(append(p.idBuf[:0], '@'), data...)
+			scoped, ok = p.lookupKey(p.idBuf)
+		}
+		if ok {
+			sb.Write(css[written:offset])
+			sb.WriteString(scoped)
+			written = offset + len(data)
+		}
+		offset += len(data)
+	}
+	if written == 0 {
+		return ""
+	}
+	sb.Write(css[written:])
+	return sb.String()
+}
@@ -0,0 +1,108 @@
+package cssmodules
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestProcessCSSModules_KeyframesScoping(t *testing.T) {
+	payload := `.a{animation:fade 1s ease-in infinite,slide steps(4,end)} @keyframes fade{from{opacity:0}to{opacity:1}} ` +
+		`@media screen{.b{animation-name:fade;-webkit-animation-name:none}} .c{transition:fade 1s}`
+	css, scopedClasses, err := ProcessCSSModules(strings.NewReader(payload), WithKeyframesScoping())
+	if err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	expected := os.Expand(`.${a}{animation:${@fade} 1s ease-in infinite,${@slide} steps(4,end)} @keyframes ${@fade}{from{opacity:0}to{opacity:1}} `+
+		`@media screen{.${b}{animation-name:${@fade};-webkit-animation-name:none}} .${c}{transition:fade 1s}`, func(s string) string { return scopedClasses[s] })
+	if len(scopedClasses) != 5 || string(css) != expected {
+		t.Errorf("unexpected css value: expected\n%q\ngot\n%q with %q map", expected, css, scopedClasses)
+	}
+
+	// The global mode leaves them as they are
+	payload = `@keyframes fade{to{opacity:1}} .a{animation:fade 1s}`
+	css, _, err = ProcessCSSModules(strings.NewReader(payload), WithKeyframesScoping(), WithMode(ModeGlobal))
+	if err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	if string(css) != payload {
+		t.Errorf("unexpected css value: expected %q got %q", payload, css)
+	}
+}
+
+func TestProcessCSSModules_CustomPropertiesScoping(t *testing.T) {
+	payload := `.a{--gap:4px;margin:var(--gap, 2px) calc(var(--gap) * 2)} @property --gap{syntax:'<length>'}`
+	css, scopedClasses, err := ProcessCSSModules(strings.NewReader(payload), WithCustomPropertiesScoping())
+	if err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	expected := os.Expand(`.${a}{${--gap}:4px;margin:var(${--gap}, 2px) calc(var(${--gap}) * 2)} @property ${--gap}{syntax:'<length>'}`,
+		func(s string) string { return scopedClasses[s] })
+	if len(scopedClasses) != 2 || string(css) != expected || !strings.HasPrefix(scopedClasses["--gap"], "--_gap_") {
+		t.Errorf("unexpected css value: expected\n%q\ngot\n%q with %q map", expected, css, scopedClasses)
+	}
+}
+
+func TestProcessHTMLWithCSSModules_StyleRewriting(t *testing.T) {
+	classes := map[string]string{"card": "RAN_1", "@fade": "KEY_1", "--gap": "--VAR_1", "#grad": "ID_1"}
+	testCases := []struct {
+		name     string
+		opts     []Option
+		payload  string
+		expected string
+	}{
+		{
+			name:     "StyleAttribute",
+			payload:  `<div css-module="card" css-module-style style="animation: fade 1s, spin 2s infinite; --gap: 4px; margin: var(--gap) var(--other)">x</div>`,
+			expected: `<div class="RAN_1" style="animation: KEY_1 1s, spin 2s infinite; --VAR_1: 4px; margin: var(--VAR_1) var(--other)">x</div>`,
+		},
+		{
+			name:     "WithoutMarker",
+			payload:  `<div style="animation: fade 1s">x</div>`,
+			expected: `<div style="animation: fade 1s">x</div>`,
+		},
+		{
+			name:     "MarkerOnly",
+			payload:  `<div css-module-style id="a" style="color: red">x</div>`,
+			expected: `<div id="a" style="color: red">x</div>`,
+		},
+		{
+			name:     "EscapedValue",
+			payload:  `<p css-module-style style="font-family: &quot;A B&quot;; animation-name: fade">x</p>`,
+			expected: `<p style="font-family: &#34;A B&#34;; animation-name: KEY_1">x</p>`,
+		},
+		{
+			name:     "StyleElement",
+			payload:  `<style css-module-style>.x{animation:fade 1s} :root{--gap:8px}</style><style>.y{animation:fade 1s}</style>`,
+			expected: `<style>.x{animation:KEY_1 1s} :root{--VAR_1:8px}</style><style>.y{animation:fade 1s}</style>`,
+		},
+		{
+			name:     "IDScoping",
+			opts:     []Option{WithIDScoping()},
+			payload:  `<path css-module-style style="fill: url(#grad); animation: fade 1s"/>`,
+			expected: `<path style="fill: url(#ID_1); animation: KEY_1 1s"/>`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := append([]Option{WithStyleRewriting()}, tc.opts...)
+			resultingHTML, err := ProcessHTMLWithCSSModules(strings.NewReader(tc.payload), classes, opts...)
+			if err != nil {
+				t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+			}
+			if string(resultingHTML) != tc.expected {
+				t.Errorf("unexpected html value: expected\n%s\ngot\n%s", tc.expected, resultingHTML)
+			}
+		})
+	}
+
+	// Without the option the marker is left as it is
+	payload := `<div css-module-style style="animation: fade 1s">x</div>`
+	resultingHTML, err := ProcessHTMLWithCSSModules(strings.NewReader(payload), classes)
+	if err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	if string(resultingHTML) != payload {
+		t.Errorf("unexpected html value: expected\n%s\ngot\n%s", payload, resultingHTML)
+	}
+}
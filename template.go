@@ -0,0 +1,316 @@
+package cssmodules
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"sort"
+	"strconv"
+	"strings"
+
+	html_parser "golang.org/x/net/html"
+)
+
+// TemplateFuncName is the name of the template function that the HTML
+// processor calls from the actions of the `css-module` attributes when
+// WithDynamicClasses is used, see FuncMap.
+const TemplateFuncName = "cssmodule"
+
+// FuncMap returns the template functions resolving the classes with the scoped
+// classes at execution time. The HTML processed with WithDynamicClasses must be
+// parsed with them. The functions fail with an error wrapping ErrClassNotFound
+// for unknown classes, unless WithMissingClassPolicy sets another policy.
+//
+//   - cssmodule returns the scoped classes of its arguments, each argument can
+//     hold several classes separated by spaces: {{cssmodule "btn" .Variant}}
+//   - cssclass is like cssmodule but returns a whole class attribute:
+//     <button {{cssclass "btn" "primary"}}>
+//   - cssmodulemap returns the scoped classes of the keys of a map[string]bool
+//     whose values are true, in sorted order: {{cssmodulemap .Classes}}
+//   - cssmodulein is like cssmodule but resolves the classes without alias with
+//     the module registered under its first argument, see WithModule:
+//     {{cssmodulein "card" .Variant}}
+func FuncMap(scopedClasses map[string]string, opts ...Option) template.FuncMap {
+	cfg := newConfig(opts)
+	t := &templateFuncs{cfg: cfg, resolver: cfg.resolverFor(scopedClasses)}
+	return template.FuncMap{
+		TemplateFuncName: t.cssmodule,
+		"cssclass":       t.cssclass,
+		"cssmodulemap":   t.cssmodulemap,
+		"cssmodulein":    t.cssmodulein,
+	}
+}
+
+type templateFuncs struct {
+	cfg      *config
+	resolver ClassResolver
+}
+
+func (t *templateFuncs) cssmodule(args ...any) (string, error) {
+	return t.resolve(t.resolver, args)
+}
+
+func (t *templateFuncs) cssmodulein(alias string, args ...any) (string, error) {
+	module, ok := t.cfg.modules[alias]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrModuleNotFound, alias)
+	}
+	return t.resolve(module, args)
+}
+
+// resolve returns the scoped classes of the classes in args.
+func (t *templateFuncs) resolve(r ClassResolver, args []any) (string, error) {
+	sb := strings.Builder{}
+	for _, arg := range args {
+		var s string
+		switch v := arg.(type) {
+		case nil:
+		case string:
+			s = v
+		default:
+			s = fmt.Sprint(v)
+		}
+		if err := t.writeClasses(&sb, r, s); err != nil {
+			return "", err
+		}
+	}
+	return sb.String(), nil
+}
+
+func (t *templateFuncs) cssclass(args ...any) (template.HTMLAttr, error) {
+	classes, err := t.cssmodule(args...)
+	if err != nil {
+		return "", err
+	}
+	return template.HTMLAttr(`class="` + template.HTMLEscapeString(classes) + `"`), nil
+}
+
+func (t *templateFuncs) cssmodulemap(m map[string]bool) (string, error) {
+	names := make([]string, 0, len(m))
+	for name, ok := range m {
+		if ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	sb := strings.Builder{}
+	for _, name := range names {
+		if err := t.writeClasses(&sb, t.resolver, name); err != nil {
+			return "", err
+		}
+	}
+	return sb.String(), nil
+}
+
+// writeClasses writes the scoped classes of the classes in s separated by
+// spaces.
+func (t *templateFuncs) writeClasses(sb *strings.Builder, r ClassResolver, s string) error {
+	for _, name := range strings.Fields(s) {
+		classes, err := t.cfg.appendClasses(nil, r, []byte(name))
+		if err != nil {
+			switch t.cfg.missingPolicy {
+			case MissingClassError:
+				return fmt.Errorf("%w: %q", err, name)
+			case MissingClassKeep:
+				classes = []string{name}
+			case MissingClassPlaceholder:
+				classes = []string{t.cfg.placeholderClass}
+			}
+		}
+		for _, class := range classes {
+			if sb.Len() != 0 {
+				sb.WriteByte(' ')
+			}
+			sb.WriteString(class)
+		}
+	}
+	return nil
+}
+
+// WithDynamicClasses makes the HTML processor compile the `css-module`
+// attributes that contain template actions instead of failing on them. The
+// static classes are scoped right away, the control actions (if, else, range,
+// with, end, ...), comments and variable declarations are kept as they are, and
+// the other actions become calls to the cssmodule template function. Names
+// joined with actions, like `btn-{{.Size}}`, are resolved as a whole at
+// execution time.
+func WithDynamicClasses() Option {
+	return func(c *config) {
+		c.dynamicClasses = true
+	}
+}
+
+// templateControls are the keywords of the actions that are kept as they are
+var templateControls = map[string]bool{
+	"if": true, "else": true, "end": true, "range": true, "with": true,
+	"break": true, "continue": true, "define": true, "block": true,
+	"template": true,
+}
+
+// templateAction is an action of a dynamic `css-module` attribute
+type templateAction struct {
+	// pipeline is the text of the action without its delimiters and trim
+	// markers
+	pipeline            []byte
+	trimLeft, trimRight bool
+}
+
+// parseAction returns the action in raw, including its delimiters.
+func parseAction(raw []byte, d *delims) templateAction {
+	a := templateAction{pipeline: raw[len(d.left):]}
+	if bytes.HasSuffix(a.pipeline, d.right) {
+		a.pipeline = a.pipeline[:len(a.pipeline)-len(d.right)]
+	}
+	if len(a.pipeline) >= 2 && a.pipeline[0] == '-' && isHTMLSpace(a.pipeline[1]) {
+		a.trimLeft = true
+		a.pipeline = a.pipeline[1:]
+	}
+	if n := len(a.pipeline); n >= 2 && a.pipeline[n-1] == '-' && isHTMLSpace(a.pipeline[n-2]) {
+		a.trimRight = true
+		a.pipeline = a.pipeline[:n-1]
+	}
+	a.pipeline = bytes.TrimSpace(a.pipeline)
+	return a
+}
+
+// isValue reports if the action writes a value, rather than being a control
+// action, a comment or a variable declaration.
+func (a *templateAction) isValue() bool {
+	if len(a.pipeline) == 0 || bytes.HasPrefix(a.pipeline, []byte("/*")) {
+		return false
+	}
+	fields := bytes.Fields(a.pipeline)
+	if templateControls[string(fields[0])] {
+		return false
+	}
+	if fields[0][0] == '$' && len(fields) > 1 && (string(fields[1]) == ":=" || string(fields[1]) == "=") {
+		return false
+	}
+	return true
+}
+
+// compileDynamicClasses returns the value of the source attribute attr
+// containing template actions with its static classes scoped and its value
+// actions calling the cssmodule template function.
+func (p *htmlProcessor) compileDynamicClasses(raw []byte, attr *htmlAttr) (string, error) {
+	val := attr.val(raw)
+	d := &p.cfg.delims
+	sb := strings.Builder{}
+	i := 0
+	for i < len(val) {
+		// Whitespace and control actions are written as they are
+		if isHTMLSpace(val[i]) {
+			sb.WriteByte(val[i])
+			i++
+			continue
+		}
+		if j := skipAction(val, i, d); j != i {
+			if a := parseAction(val[i:j], d); !a.isValue() {
+				sb.Write(val[i:j])
+				i = j
+				continue
+			}
+		}
+
+		// A word is made of names and value actions until the next whitespace
+		// or control action
+		start, dynamic := i, false
+		for i < len(val) && !isHTMLSpace(val[i]) {
+			j := skipAction(val, i, d)
+			if j == i {
+				i++
+				continue
+			}
+			if a := parseAction(val[i:j], d); !a.isValue() {
+				break
+			}
+			dynamic = true
+			i = j
+		}
+		word := val[start:i]
+
+		if !dynamic {
+			if bytes.IndexByte(word, '&') != -1 {
+				word = []byte(html_parser.UnescapeString(string(word)))
+			}
+			classes, err := p.cfg.appendClasses(nil, p.resolver, word)
+			if err != nil {
+				class, err := p.missingClass(word, p.positionOf(raw, attr.valStart+start), err)
+				if err != nil {
+					return "", err
+				}
+				sb.WriteString(class)
+				continue
+			}
+			for i, class := range classes {
+				if i != 0 {
+					sb.WriteByte(' ')
+				}
+				sb.WriteString(html_parser.EscapeString(class))
+			}
+			continue
+		}
+		p.compileDynamicWord(&sb, word)
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+// compileDynamicWord writes the action calling the cssmodule template function
+// with a word made of names and value actions. The names and the values are
+// joined with printf, the trim markers of the first and last actions are kept.
+func (p *htmlProcessor) compileDynamicWord(sb *strings.Builder, word []byte) {
+	d := &p.cfg.delims
+	var format []byte
+	var pipelines [][]byte
+	var trimLeft, trimRight bool
+	for i := 0; i < len(word); {
+		j := skipAction(word, i, d)
+		if j == i {
+			if word[i] == '%' {
+				format = append(format, '%')
+			}
+			format = append(format, word[i])
+			i++
+			trimRight = false
+			continue
+		}
+		a := parseAction(word[i:j], d)
+		if i == 0 {
+			trimLeft = a.trimLeft
+		}
+		trimRight = a.trimRight
+		format = append(format, "%v"...)
+		pipelines = append(pipelines, a.pipeline)
+		i = j
+	}
+
+	sb.Write(d.left)
+	if trimLeft {
+		sb.WriteString("- ")
+	}
+	if p.alias != "" {
+		// The names without alias are resolved with the module of the scope
+		sb.WriteString("cssmodulein ")
+		sb.WriteString(strconv.Quote(p.alias))
+	} else {
+		sb.WriteString(TemplateFuncName)
+	}
+	if len(pipelines) == 1 && string(format) == "%v" {
+		sb.WriteString(" (")
+		sb.Write(pipelines[0])
+		sb.WriteByte(')')
+	} else {
+		sb.WriteString(" (printf ")
+		sb.WriteString(strconv.Quote(string(format)))
+		for _, pipeline := range pipelines {
+			sb.WriteString(" (")
+			sb.Write(pipeline)
+			sb.WriteByte(')')
+		}
+		sb.WriteByte(')')
+	}
+	if trimRight {
+		sb.WriteString(" -")
+	}
+	sb.Write(d.right)
+}
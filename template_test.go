@@ -0,0 +1,112 @@
+package cssmodules
+
+import (
+	"errors"
+	"html/template"
+	"strings"
+	"testing"
+)
+
+func TestFuncMap_cssmodule(t *testing.T) {
+	classes := map[string]string{"test-1": "RAN_1", "test-2": "RAN_2", "testThree": "RAN_3"}
+	payload := `<button css-module="test-1 {{if .Primary}}test-2{{end}} {{.Extra}} test-{{.Size}}">Ok</button>`
+
+	processed, err := ProcessHTMLWithCSSModules(strings.NewReader(payload), classes, WithDynamicClasses())
+	if err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	tmpl := template.Must(template.New("").Funcs(FuncMap(classes, WithLocalsConvention(LocalsCamelCase))).Parse(string(processed)))
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, map[string]any{"Primary": true, "Extra": "test-three", "Size": 2}); err != nil {
+		t.Fatalf("unexpected error value: expected <nil> got %q", err.Error())
+	}
+	expectedHTML := `<button class="RAN_1 RAN_2 RAN_3 RAN_2">Ok</button>`
+	if sb.String() != expectedHTML {
+		t.Errorf("unexpected html value: expected %s got %s", expectedHTML, sb.String())
+	}
+
+	err = tmpl.Execute(&strings.Builder{}, map[string]any{"Size": 4})
+	if !errors.Is(err, ErrClassNotFound) {
+		t.Errorf("unexpected error value: expected %v got %v", ErrClassNotFound, err)
+	}
+}
+
+var testCasesFuncMap = []struct {
+	name         string
+	template     string
+	data         any
+	expectedHTML string
+	expectedErr  error
+}{
+	{
+		name:         "cssmodule",
+		expectedHTML: `<button class="RAN_1 RAN_2 RAN_3">Ok</button>`,
+
+		template: `<button class="{{cssmodule "test-1" "test-2 testThree"}}">Ok</button>`,
+	},
+	{
+		name:         "cssclass",
+		expectedHTML: `<button class="RAN_1 RAN_3">Ok</button>`,
+
+		template: `<button {{cssclass "test-1" .}}>Ok</button>`,
+		data:     "test-three",
+	},
+	{
+		name:         "cssmodulemap",
+		expectedHTML: `<button class="RAN_2 RAN_3">Ok</button>`,
+
+		template: `<button class="{{cssmodulemap .}}">Ok</button>`,
+		data:     map[string]bool{"testThree": true, "test-1": false, "test-2": true},
+	},
+	{
+		name:         "cssmodulein",
+		expectedHTML: `<div class="CARD_1 RAN_1">Ok</div>`,
+
+		template: `<div class="{{cssmodulein "card" "root" "test:test-1"}}">Ok</div>`,
+	},
+	{
+		name:        "UnknownModule",
+		expectedErr: ErrModuleNotFound,
+
+		template: `<div class="{{cssmodule "header:root"}}">Ok</div>`,
+	},
+	{
+		name:        "UnknownClass",
+		expectedErr: ErrClassNotFound,
+
+		template: `<button {{cssclass "test-1" "test-4"}}>Ok</button>`,
+	},
+	{
+		name:        "UnknownMapClass",
+		expectedErr: ErrClassNotFound,
+
+		template: `<button class="{{cssmodulemap .}}">Ok</button>`,
+		data:     map[string]bool{"test-4": true},
+	},
+}
+
+func TestFuncMap(t *testing.T) {
+	classes := map[string]string{"test-1": "RAN_1", "test-2": "RAN_2", "testThree": "RAN_3"}
+	funcs := FuncMap(classes,
+		WithLocalsConvention(LocalsCamelCase),
+		WithModule("card", map[string]string{"root": "CARD_1"}),
+		WithModule("test", classes),
+	)
+	for i := range testCasesFuncMap {
+		tc := testCasesFuncMap[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tmpl := template.Must(template.New("").Funcs(funcs).Parse(tc.template))
+			var sb strings.Builder
+			err := tmpl.Execute(&sb, tc.data)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Errorf("unexpected error value: expected %v got %v", tc.expectedErr, err)
+				return
+			}
+			if err == nil && sb.String() != tc.expectedHTML {
+				t.Errorf("unexpected html value: expected %s got %s", tc.expectedHTML, sb.String())
+			}
+		})
+	}
+}